@@ -141,22 +141,26 @@ func buildStack(builder *stackbuilder, prefix string, stack []byte, resolver sym
 	if len(stack) == 0 {
 		return
 	}
-	var stackFrames []string
+	var addrs []uint64
 	for i := 0; i < 127; i++ {
 		instructionPointerBytes := stack[i*8 : i*8+8]
 		ins := binary.LittleEndian.Uint64(instructionPointerBytes)
 		if ins == 0 {
 			break
 		}
-		sym := resolver.Resolve(ins)
+		addrs = append(addrs, ins)
+	}
+	var stackFrames []string
+	// frames are ordered innermost first, same as the stack itself
+	for _, sym := range resolver.ResolveStack(addrs) {
 		var name string
 		if sym.Name != "" {
 			name = sym.Name
 		} else {
-			if sym.Module != "" {
+			if !sym.UnknownModule {
 				name = fmt.Sprintf("%s+%x", sym.Module, sym.Start)
 			} else {
-				name = fmt.Sprintf("%x", ins)
+				name = fmt.Sprintf("%x", sym.Addr)
 			}
 		}
 		stackFrames = append(stackFrames, fmt.Sprintf("%s%s", prefix, name))
@@ -3,13 +3,16 @@ package proc
 import (
 	"fmt"
 	"os"
+	"sync"
 	"syscall"
 
 	"github.com/golang/glog"
 	"golang.org/x/sys/unix"
 )
 
+// Stat is safe for concurrent use.
 type Stat struct {
+	mu             sync.Mutex
 	procfs         string
 	rootSymlink    string
 	mountNsSymlink string
@@ -40,6 +43,12 @@ func ProcStat(pid int) (*Stat, error) {
 }
 
 func (s *Stat) RefreshRoot() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshRoot()
+}
+
+func (s *Stat) refreshRoot() bool {
 	// Try to get current root and current mount namespace for the process
 	// If an error is raise, that means the process might not exists anymore;
 	// keep the old fd
@@ -68,14 +77,25 @@ func (s *Stat) RefreshRoot() bool {
 	return s.rootFd != oldFd
 }
 
-func (s *Stat) GetRootFD() int { return s.rootFd }
+func (s *Stat) GetRootFD() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rootFd
+}
 
 func (s *Stat) IsStale() bool {
 	inode, _ := getinode(s.procfs)
-	return inode != s.inode && s.RefreshRoot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return inode != s.inode && s.refreshRoot()
 }
 
-func (s *Stat) Reset() { s.inode, _ = getinode(s.procfs) }
+func (s *Stat) Reset() {
+	inode, _ := getinode(s.procfs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inode = inode
+}
 
 func getinode(procfs string) (uint64, error) {
 	var stat unix.Stat_t
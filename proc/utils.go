@@ -1,6 +1,8 @@
 package proc
 
 import (
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"golang.org/x/sys/unix"
@@ -10,6 +12,14 @@ func IsPerfMap(path string) bool {
 	return strings.HasSuffix(path, ".map")
 }
 
+var jitDumpRe = regexp.MustCompile(`^jit-\d+\.dump$`)
+
+// IsJitDump reports whether path names a jitdump file. JIT runtimes mmap the
+// dump as executable so that perf records it, this is how it is discovered.
+func IsJitDump(path string) bool {
+	return jitDumpRe.MatchString(filepath.Base(path))
+}
+
 func IsValidPerfMap(path string) bool {
 	return IsPerfMap(path) && unix.Access(path, unix.R_OK) == nil
 }
@@ -0,0 +1,296 @@
+package syms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/vietanhduong/profiling/syms/elf"
+)
+
+const (
+	debuginfodDebugInfo  = "debuginfo"
+	debuginfodExecutable = "executable"
+	// suffix of the marker recording that no server has an artifact
+	debuginfodMissSuffix = ".miss"
+
+	defaultDebuginfodTimeout     = 30 * time.Second
+	defaultDebuginfodNegativeTTL = 10 * time.Minute
+	defaultDebuginfodMaxSize     = 2 << 30
+)
+
+// ErrDebuginfodNotFound is returned when no server has the requested file,
+// or when the miss is cached.
+var ErrDebuginfodNotFound = errors.New("debuginfod: not found")
+
+// DebuginfodOptions configure a DebuginfodClient.
+type DebuginfodOptions struct {
+	// URLs of the debuginfod servers, queried in order.
+	URLs []string
+	// CacheDir stores the downloaded files, as <build id>/debuginfo and
+	// <build id>/executable.
+	CacheDir string
+	// Timeout bounds each request, 30s if zero.
+	Timeout time.Duration
+	// NegativeTTL is how long a file which no server has is not requested
+	// again, 10m if zero.
+	NegativeTTL time.Duration
+	// MaxSize is the size of the largest file downloaded, 2GiB if zero.
+	MaxSize int64
+}
+
+// DebuginfodClient downloads the debug files and executables of build IDs
+// from debuginfod servers, see
+// https://sourceware.org/elfutils/Debuginfod.html.
+type DebuginfodClient struct {
+	urls        []string
+	cacheDir    string
+	negativeTTL time.Duration
+	maxSize     int64
+	client      *http.Client
+
+	mu sync.Mutex
+	// callbacks of the background downloads, by cache path
+	inflight map[string][]func(string)
+}
+
+// NewDebuginfodClient returns a client of the servers in opts.
+func NewDebuginfodClient(opts DebuginfodOptions) (*DebuginfodClient, error) {
+	var urls []string
+	for _, u := range opts.URLs {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("debuginfod: no server URL")
+	}
+	if opts.CacheDir == "" {
+		return nil, fmt.Errorf("debuginfod: no cache dir")
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create debuginfod cache dir: %w", err)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultDebuginfodTimeout
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = defaultDebuginfodNegativeTTL
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultDebuginfodMaxSize
+	}
+	return &DebuginfodClient{
+		urls:        urls,
+		cacheDir:    opts.CacheDir,
+		negativeTTL: opts.NegativeTTL,
+		maxSize:     opts.MaxSize,
+		client:      &http.Client{Timeout: opts.Timeout},
+		inflight:    make(map[string][]func(string)),
+	}, nil
+}
+
+// NewDebuginfodClientFromEnv returns a client configured like the elfutils
+// client: DEBUGINFOD_URLS lists the servers separated by spaces,
+// DEBUGINFOD_TIMEOUT is the timeout in seconds and DEBUGINFOD_CACHE_PATH the
+// cache dir, which defaults to $XDG_CACHE_HOME/debuginfod_client. It returns
+// nil and no error if DEBUGINFOD_URLS is not set.
+func NewDebuginfodClientFromEnv() (*DebuginfodClient, error) {
+	urls := strings.Fields(os.Getenv("DEBUGINFOD_URLS"))
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	opts := DebuginfodOptions{URLs: urls, CacheDir: os.Getenv("DEBUGINFOD_CACHE_PATH")}
+	if s := os.Getenv("DEBUGINFOD_TIMEOUT"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEBUGINFOD_TIMEOUT %q: %w", s, err)
+		}
+		opts.Timeout = time.Duration(secs) * time.Second
+	}
+	if opts.CacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("debuginfod cache dir: %w", err)
+		}
+		opts.CacheDir = filepath.Join(dir, "debuginfod_client")
+	}
+	return NewDebuginfodClient(opts)
+}
+
+// DebugInfo returns the path of the debug file of the build ID id,
+// downloading it if it is not cached yet.
+func (c *DebuginfodClient) DebugInfo(ctx context.Context, id elf.BuildId) (string, error) {
+	return c.fetch(ctx, id, debuginfodDebugInfo)
+}
+
+// Executable returns the path of the executable of the build ID id,
+// downloading it if it is not cached yet.
+func (c *DebuginfodClient) Executable(ctx context.Context, id elf.BuildId) (string, error) {
+	return c.fetch(ctx, id, debuginfodExecutable)
+}
+
+// cached returns the path of the artifact of id if it is in the cache.
+func (c *DebuginfodClient) cached(id elf.BuildId, artifact string) (string, bool) {
+	if !id.GNU() || !isHex(id.Id) {
+		return "", false
+	}
+	path := filepath.Join(c.cacheDir, id.Id, artifact)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// fetchAsync downloads the artifact of id in the background and calls done
+// with its path once downloaded, or with "" if it could not be. Requests of
+// an artifact being downloaded share the download.
+func (c *DebuginfodClient) fetchAsync(id elf.BuildId, artifact string, done func(path string)) {
+	key := filepath.Join(id.Id, artifact)
+	c.mu.Lock()
+	callbacks, ok := c.inflight[key]
+	c.inflight[key] = append(callbacks, done)
+	c.mu.Unlock()
+	if ok {
+		return
+	}
+	go func() {
+		path, err := c.fetch(context.Background(), id, artifact)
+		c.mu.Lock()
+		callbacks := c.inflight[key]
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		if err != nil && !errors.Is(err, ErrDebuginfodNotFound) {
+			glog.Warningf("Failed to fetch %s (build_id=%s): %v", artifact, id.Id, err)
+		}
+		for _, done := range callbacks {
+			done(path)
+		}
+	}()
+}
+
+func (c *DebuginfodClient) fetch(ctx context.Context, id elf.BuildId, artifact string) (string, error) {
+	if !id.GNU() || !isHex(id.Id) {
+		return "", fmt.Errorf("debuginfod: invalid build id %q", id.Id)
+	}
+	dir := filepath.Join(c.cacheDir, id.Id)
+	path := filepath.Join(dir, artifact)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	miss := path + debuginfodMissSuffix
+	if info, err := os.Stat(miss); err == nil && time.Since(info.ModTime()) < c.negativeTTL {
+		return "", ErrDebuginfodNotFound
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create debuginfod cache dir: %w", err)
+	}
+
+	// errors other than not found, which are not cached
+	var errs []error
+	for _, u := range c.urls {
+		err := c.download(ctx, fmt.Sprintf("%s/buildid/%s/%s", u, id.Id, artifact), id, path)
+		if err == nil {
+			glog.V(5).Infof("Downloaded %s of build id %s from %s", artifact, id.Id, u)
+			_ = os.Remove(miss)
+			return path, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !errors.Is(err, ErrDebuginfodNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	if f, err := os.Create(miss); err == nil {
+		f.Close()
+	}
+	return "", ErrDebuginfodNotFound
+}
+
+// download writes the file at url to path once its build ID is checked.
+func (c *DebuginfodClient) download(ctx context.Context, url string, id elf.BuildId, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("debuginfod: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrDebuginfodNotFound, url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("debuginfod: %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength > c.maxSize {
+		return fmt.Errorf("debuginfod: %s: file of %d bytes is too large", url, resp.ContentLength)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create debuginfod cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, c.maxSize+1))
+	if err == nil && n > c.maxSize {
+		err = fmt.Errorf("file larger than %d bytes", c.maxSize)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("debuginfod: %s: %w", url, err)
+	}
+	if err = checkBuildId(tmp.Name(), id); err != nil {
+		return fmt.Errorf("debuginfod: %s: %w", url, err)
+	}
+	// readers see either no file or the complete one
+	if err = os.Rename(tmp.Name(), path); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("write debuginfod cache entry: %w", err)
+	}
+	return nil
+}
+
+func checkBuildId(path string, id elf.BuildId) error {
+	mf, err := elf.NewMMapedElfFile(path)
+	if err != nil {
+		return err
+	}
+	defer mf.Close()
+	got, err := mf.BuildId()
+	if err != nil {
+		return err
+	}
+	if got != id {
+		return fmt.Errorf("build id mismatch: %s", got.Id)
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package syms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+)
+
+const testBuildId = "1fcfa068c5fdb9f31e6d9f3f89019beacb70182d"
+
+// newDebuginfodServer serves files by request path and counts the requests.
+func newDebuginfodServer(t *testing.T, files map[string]string, status int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, f)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestDebuginfodClient(t *testing.T) {
+	srv, requests := newDebuginfodServer(t, map[string]string{
+		"/buildid/" + testBuildId + "/debuginfo":  "elf/testdata/elfs/elf.debug",
+		"/buildid/" + testBuildId + "/executable": "elf/testdata/elfs/elf",
+		// served under the wrong build id
+		"/buildid/73e42a051f5f5e392c00b8d0fa95dc75c8b07458/debuginfo": "elf/testdata/elfs/elf.debug",
+	}, http.StatusOK)
+	dir := t.TempDir()
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL + "/"}, CacheDir: dir})
+	require.NoError(t, err)
+	ctx := context.Background()
+	id := elf.GNUBuildId(testBuildId)
+
+	path, err := client.DebugInfo(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, testBuildId, "debuginfo"), path)
+	expected, err := os.ReadFile("elf/testdata/elfs/elf.debug")
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	// cached
+	path, err = client.DebugInfo(ctx, id)
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+	assert.Equal(t, int32(1), requests.Load())
+
+	path, err = client.Executable(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, testBuildId, "executable"), path)
+	assert.Equal(t, int32(2), requests.Load())
+
+	_, err = client.DebugInfo(ctx, elf.GNUBuildId("73e42a051f5f5e392c00b8d0fa95dc75c8b07458"))
+	require.ErrorContains(t, err, "build id mismatch")
+	assert.NoFileExists(t, filepath.Join(dir, "73e42a051f5f5e392c00b8d0fa95dc75c8b07458", "debuginfo"))
+
+	for _, id := range []elf.BuildId{elf.GNUBuildId("../../etc"), elf.GNUBuildId(""), elf.GoBuildId("abcd")} {
+		_, err = client.DebugInfo(ctx, id)
+		require.ErrorContains(t, err, "invalid build id")
+	}
+}
+
+func TestDebuginfodClient_NegativeCache(t *testing.T) {
+	srv, requests := newDebuginfodServer(t, nil, http.StatusOK)
+	dir := t.TempDir()
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL}, CacheDir: dir, NegativeTTL: time.Hour})
+	require.NoError(t, err)
+	ctx := context.Background()
+	id := elf.GNUBuildId(testBuildId)
+
+	_, err = client.DebugInfo(ctx, id)
+	require.ErrorIs(t, err, ErrDebuginfodNotFound)
+	_, err = client.DebugInfo(ctx, id)
+	require.ErrorIs(t, err, ErrDebuginfodNotFound)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// requested again once the miss expires
+	miss := filepath.Join(dir, testBuildId, "debuginfo"+debuginfodMissSuffix)
+	expired := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(miss, expired, expired))
+	_, err = client.DebugInfo(ctx, id)
+	require.ErrorIs(t, err, ErrDebuginfodNotFound)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestDebuginfodClient_Servers(t *testing.T) {
+	empty, _ := newDebuginfodServer(t, nil, http.StatusOK)
+	broken, brokenRequests := newDebuginfodServer(t, nil, http.StatusInternalServerError)
+	srv, _ := newDebuginfodServer(t, map[string]string{
+		"/buildid/" + testBuildId + "/debuginfo": "elf/testdata/elfs/elf.debug",
+	}, http.StatusOK)
+	ctx := context.Background()
+	id := elf.GNUBuildId(testBuildId)
+
+	t.Run("fallback", func(t *testing.T) {
+		client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{empty.URL, broken.URL, srv.URL}, CacheDir: t.TempDir()})
+		require.NoError(t, err)
+		_, err = client.DebugInfo(ctx, id)
+		require.NoError(t, err)
+	})
+
+	t.Run("transient errors are not cached", func(t *testing.T) {
+		client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{empty.URL, broken.URL}, CacheDir: t.TempDir()})
+		require.NoError(t, err)
+		before := brokenRequests.Load()
+		for i := 0; i < 2; i++ {
+			_, err = client.DebugInfo(ctx, id)
+			require.Error(t, err)
+			require.NotErrorIs(t, err, ErrDebuginfodNotFound)
+		}
+		assert.Equal(t, before+2, brokenRequests.Load())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer slow.Close()
+		defer close(done)
+		client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{slow.URL}, CacheDir: t.TempDir(), Timeout: 50 * time.Millisecond})
+		require.NoError(t, err)
+		_, err = client.DebugInfo(ctx, id)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrDebuginfodNotFound)
+	})
+}
+
+func TestNewDebuginfodClientFromEnv(t *testing.T) {
+	t.Setenv("DEBUGINFOD_URLS", "")
+	client, err := NewDebuginfodClientFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, client)
+
+	dir := t.TempDir()
+	t.Setenv("DEBUGINFOD_URLS", "https://a.example.com/ https://b.example.com")
+	t.Setenv("DEBUGINFOD_TIMEOUT", "5")
+	t.Setenv("DEBUGINFOD_CACHE_PATH", dir)
+	client, err = NewDebuginfodClientFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, client.urls)
+	assert.Equal(t, 5*time.Second, client.client.Timeout)
+	assert.Equal(t, dir, client.cacheDir)
+
+	t.Setenv("DEBUGINFOD_TIMEOUT", "soon")
+	_, err = NewDebuginfodClientFromEnv()
+	require.Error(t, err)
+}
+
+func TestProcModule_Debuginfod(t *testing.T) {
+	srv, _ := newDebuginfodServer(t, map[string]string{
+		"/buildid/" + testBuildId + "/debuginfo": "elf/testdata/elfs/elf.debug",
+	}, http.StatusOK)
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL}, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+
+	path := "elf/testdata/elfs/elf.stripped"
+	procmap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+	m := NewProcModule(path, procmap, newProcPath(path, 0, -1, true), &SymbolOptions{
+		DemangleType: DemangleFull,
+		UseDebugFile: true,
+		Debuginfod:   client,
+	})
+	defer m.Cleanup()
+	addr := uint64(0x555555554000 + 0x1149)
+	// the debug file is downloaded in the background, then the tables are
+	// reloaded
+	require.Empty(t, m.Resolve(addr))
+	require.Eventually(t, func() bool {
+		return m.Resolve(addr) == "iter"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the cached debug file is used at once
+	m2 := NewProcModule(path, procmap, newProcPath(path, 0, -1, true), m.opts)
+	defer m2.Cleanup()
+	require.Equal(t, "iter", m2.Resolve(addr))
+}
+
+func TestProcModule_DebuginfodDwarf(t *testing.T) {
+	const dwarfBuildId = "73e42a051f5f5e392c00b8d0fa95dc75c8b07458"
+	srv, _ := newDebuginfodServer(t, map[string]string{
+		"/buildid/" + dwarfBuildId + "/debuginfo": "elf/testdata/elfs/elf.dwarf.debug",
+	}, http.StatusOK)
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL}, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	store := NewTableStore(1 << 30)
+	opts := &SymbolOptions{UseDwarf: true, Debuginfod: client, Tables: store}
+
+	path := "elf/testdata/elfs/elf.dwarf.stripped"
+	procmap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+	addr := uint64(0x555555554000 + 0x1139)
+	m := newTestModule(t, path, procmap, opts)
+	defer m.Cleanup()
+	// the tables loaded without the DWARF info of the debug file are not
+	// shared
+	file, _ := m.ResolveLine(addr)
+	require.Empty(t, file)
+	assert.Equal(t, 0, store.Stats().Tables)
+
+	require.Eventually(t, func() bool {
+		file, _ := m.ResolveLine(addr)
+		return file != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	_, line := m.ResolveLine(addr)
+	assert.Equal(t, 9, line)
+
+	// the tables are shared once complete
+	m2 := newTestModule(t, path, procmap, opts)
+	defer m2.Cleanup()
+	_, line = m2.ResolveLine(addr)
+	assert.Equal(t, 9, line)
+	assert.Equal(t, 1, store.Stats().Tables)
+}
+
+func TestProcModule_DebuginfodNoBlock(t *testing.T) {
+	done := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+		http.NotFound(w, r)
+	}))
+	defer slow.Close()
+	dir := t.TempDir()
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{slow.URL}, CacheDir: dir})
+	require.NoError(t, err)
+
+	path := "elf/testdata/elfs/elf.stripped"
+	procmap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+	m := NewProcModule(path, procmap, newProcPath(path, 0, -1, true), &SymbolOptions{
+		UseDebugFile: true,
+		Debuginfod:   client,
+	})
+	defer m.Cleanup()
+	start := time.Now()
+	require.Empty(t, m.Resolve(0x555555554000+0x1149))
+	require.Less(t, time.Since(start), time.Second)
+
+	// the download ends with the miss recorded
+	close(done)
+	miss := filepath.Join(dir, testBuildId, "debuginfo"+debuginfodMissSuffix)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(miss)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDebuginfodClient_MaxSize(t *testing.T) {
+	srv, _ := newDebuginfodServer(t, map[string]string{
+		"/buildid/" + testBuildId + "/debuginfo": "elf/testdata/elfs/elf.debug",
+	}, http.StatusOK)
+	dir := t.TempDir()
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL}, CacheDir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+	id := elf.GNUBuildId(testBuildId)
+	_, err = client.DebugInfo(context.Background(), id)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrDebuginfodNotFound)
+	assert.NoFileExists(t, filepath.Join(dir, testBuildId, "debuginfo"))
+
+	// the body is capped when its length is unknown
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := os.ReadFile("elf/testdata/elfs/elf.debug")
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(data)
+	}))
+	defer chunked.Close()
+	client, err = NewDebuginfodClient(DebuginfodOptions{URLs: []string{chunked.URL}, CacheDir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+	_, err = client.DebugInfo(context.Background(), id)
+	require.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, testBuildId, "debuginfo"))
+}
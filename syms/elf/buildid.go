@@ -2,9 +2,11 @@ package elf
 
 import (
 	"bytes"
+	"debug/elf"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type BuildId struct {
@@ -52,19 +54,20 @@ func (f *MMapedElfFile) BuildId() (BuildId, error) {
 var goBuildIDSep = []byte("/")
 
 func (f *MMapedElfFile) GoBuildId() (BuildId, error) {
-	buildIDSection := f.Section(".note.go.buildid")
-	if buildIDSection == nil {
+	var data []byte
+	if buildIDSection := f.Section(".note.go.buildid"); buildIDSection != nil {
+		var err error
+		if data, err = f.SectionData(buildIDSection); err != nil {
+			return BuildId{}, fmt.Errorf("reading .note.go.buildid %w", err)
+		}
+		if len(data) < 17 {
+			return BuildId{}, fmt.Errorf(".note.gnu.build-id is too small")
+		}
+		data = data[16 : len(data)-1]
+	} else if data = f.findNote("Go", noteGoBuildID); data == nil {
 		return BuildId{}, ErrNoBuildIDSection
 	}
-	data, err := f.SectionData(buildIDSection)
-	if err != nil {
-		return BuildId{}, fmt.Errorf("reading .note.go.buildid %w", err)
-	}
-	if len(data) < 17 {
-		return BuildId{}, fmt.Errorf(".note.gnu.build-id is too small")
-	}
 
-	data = data[16 : len(data)-1]
 	if len(data) < 40 || bytes.Count(data, goBuildIDSep) < 2 {
 		return BuildId{}, fmt.Errorf("wrong .note.go.buildid %s", f.fpath)
 	}
@@ -76,25 +79,73 @@ func (f *MMapedElfFile) GoBuildId() (BuildId, error) {
 }
 
 func (f *MMapedElfFile) GNUBuildId() (BuildId, error) {
-	buildIDSection := f.Section(".note.gnu.build-id")
-	if buildIDSection == nil {
+	var rawBuildID []byte
+	if buildIDSection := f.Section(".note.gnu.build-id"); buildIDSection != nil {
+		data, err := f.SectionData(buildIDSection)
+		if err != nil {
+			return BuildId{}, fmt.Errorf("reading .note.gnu.build-id %w", err)
+		}
+		if len(data) < 16 {
+			return BuildId{}, fmt.Errorf(".note.gnu.build-id is too small")
+		}
+		if !bytes.Equal([]byte("GNU"), data[12:15]) {
+			return BuildId{}, fmt.Errorf(".note.gnu.build-id is not a GNU build-id")
+		}
+		rawBuildID = data[16:]
+	} else if rawBuildID = f.findNote("GNU", noteGNUBuildID); rawBuildID == nil {
 		return BuildId{}, ErrNoBuildIDSection
 	}
-
-	data, err := f.SectionData(buildIDSection)
-	if err != nil {
-		return BuildId{}, fmt.Errorf("reading .note.gnu.build-id %w", err)
-	}
-	if len(data) < 16 {
-		return BuildId{}, fmt.Errorf(".note.gnu.build-id is too small")
-	}
-	if !bytes.Equal([]byte("GNU"), data[12:15]) {
-		return BuildId{}, fmt.Errorf(".note.gnu.build-id is not a GNU build-id")
-	}
-	rawBuildID := data[16:]
 	if len(rawBuildID) != 20 && len(rawBuildID) != 8 { // 8 is xxhash, for example in Container-Optimized OS
 		return BuildId{}, fmt.Errorf(".note.gnu.build-id has wrong size %s", f.fpath)
 	}
 	buildIDHex := hex.EncodeToString(rawBuildID)
 	return GNUBuildId(buildIDHex), nil
 }
+
+const (
+	noteGNUBuildID = 3 // NT_GNU_BUILD_ID
+	noteGoBuildID  = 4
+)
+
+// findNote returns the descriptor of the first note with the given owner and
+// type in the PT_NOTE segments, for files whose note sections are gone.
+func (f *MMapedElfFile) findNote(name string, typ uint32) []byte {
+	if err := f.ensureOpen(); err != nil {
+		return nil
+	}
+	for i := range f.Progs {
+		p := &f.Progs[i]
+		if p.Type != elf.PT_NOTE || p.Filesz > 1<<20 {
+			continue
+		}
+		data := make([]byte, p.Filesz)
+		if err := f.readAt(data, int64(p.Off)); err != nil {
+			continue
+		}
+		// notes of segments aligned to 8 are padded to 8
+		align := uint64(4)
+		if p.Align == 8 {
+			align = 8
+		}
+		pad := func(n uint64) uint64 { return (n + align - 1) &^ (align - 1) }
+		for len(data) >= 12 {
+			namesz := uint64(f.ByteOrder.Uint32(data))
+			descsz := uint64(f.ByteOrder.Uint32(data[4:]))
+			ntype := f.ByteOrder.Uint32(data[8:])
+			nameEnd := 12 + pad(namesz)
+			descEnd := nameEnd + pad(descsz)
+			if nameEnd+descsz > uint64(len(data)) {
+				break
+			}
+			// the Go linker pads the owner with NULs, "Go\x00\x00"
+			if ntype == typ && strings.TrimRight(string(data[12:12+namesz]), "\x00") == name {
+				return data[nameEnd : nameEnd+descsz]
+			}
+			if descEnd >= uint64(len(data)) {
+				break
+			}
+			data = data[descEnd:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// The Go linker writes the toolchain version and the module information to
+// the .go.buildinfo section, after a 32 bytes header:
+//
+//	"\xff Go buildinf:" ptrSize flags
+//
+// Before go1.18 the header is followed by two pointers to Go strings, since
+// then the strings are stored inline, prefixed by their varint length.
+
+// BuildInfo is the build information of a Go binary.
+type BuildInfo = debug.BuildInfo
+
+var ErrNoBuildInfo = errors.New("go build info not found")
+
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+const (
+	buildInfoHeaderSize = 32
+	buildInfoAlign      = 16
+	// maxBuildInfoSize bounds the version and module info strings.
+	maxBuildInfoSize = 1 << 20
+
+	buildInfoBigEndian = 1 << 0
+	buildInfoInline    = 1 << 1
+)
+
+// GoBuildInfo returns the Go version, module and build settings embedded in
+// a Go binary.
+func (f *MMapedElfFile) GoBuildInfo() (*BuildInfo, error) {
+	data, err := f.buildInfoData()
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(data, buildInfoMagic)
+	if i < 0 || len(data)-i < buildInfoHeaderSize {
+		return nil, ErrNoBuildInfo
+	}
+	data = data[i:]
+
+	var vers, mod string
+	ptrSize, flags := int(data[14]), data[15]
+	if flags&buildInfoInline != 0 {
+		data = data[buildInfoHeaderSize:]
+		var ok bool
+		if vers, data, ok = readVarintString(data); !ok {
+			return nil, fmt.Errorf("invalid go version in build info")
+		}
+		if mod, _, ok = readVarintString(data); !ok {
+			return nil, fmt.Errorf("invalid module info in build info")
+		}
+	} else {
+		if ptrSize != 4 && ptrSize != 8 {
+			return nil, fmt.Errorf("invalid pointer size %d in build info", ptrSize)
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if flags&buildInfoBigEndian != 0 {
+			order = binary.BigEndian
+		}
+		if vers, err = f.readGoString(order, ptrSize, readPtr(order, ptrSize, data[16:])); err != nil {
+			return nil, fmt.Errorf("read go version: %w", err)
+		}
+		if mod, err = f.readGoString(order, ptrSize, readPtr(order, ptrSize, data[16+ptrSize:])); err != nil {
+			return nil, fmt.Errorf("read module info: %w", err)
+		}
+	}
+	if vers == "" {
+		return nil, ErrNoBuildInfo
+	}
+
+	// The module info is wrapped in 16 bytes sentinels, see
+	// cmd/go/internal/modload.ModInfoData.
+	if len(mod) >= 33 && mod[len(mod)-17] == '\n' {
+		mod = mod[16 : len(mod)-16]
+	} else {
+		mod = ""
+	}
+	bi, err := debug.ParseBuildInfo(mod)
+	if err != nil {
+		return nil, fmt.Errorf("parse module info: %w", err)
+	}
+	bi.GoVersion = vers
+	return bi, nil
+}
+
+// buildInfoData returns the contents of the .go.buildinfo section or, when
+// section headers are missing, of the first writable segment, where the
+// linker places it.
+func (f *MMapedElfFile) buildInfoData() ([]byte, error) {
+	if s := f.Section(".go.buildinfo"); s != nil {
+		data, err := f.SectionData(s)
+		if err != nil {
+			return nil, fmt.Errorf("read .go.buildinfo: %w", err)
+		}
+		return data, nil
+	}
+	if len(f.Sections) != 0 {
+		return nil, ErrNoBuildInfo
+	}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Flags&elf.PF_W == 0 {
+			continue
+		}
+		if err := f.ensureOpen(); err != nil {
+			return nil, err
+		}
+		data := make([]byte, min(p.Filesz, 64<<10))
+		if err := f.readAt(data, int64(p.Off)); err != nil {
+			return nil, fmt.Errorf("read writable segment: %w", err)
+		}
+		for off := 0; off+buildInfoHeaderSize <= len(data); off += buildInfoAlign {
+			if bytes.HasPrefix(data[off:], buildInfoMagic) {
+				return data[off:], nil
+			}
+		}
+		break
+	}
+	return nil, ErrNoBuildInfo
+}
+
+// readGoString reads the Go string header (data pointer, length) at addr and
+// the string it points to.
+func (f *MMapedElfFile) readGoString(order binary.ByteOrder, ptrSize int, addr uint64) (string, error) {
+	r := &vaddrReader{f: f}
+	hdr := make([]byte, 2*ptrSize)
+	if err := r.ReadAt(hdr, int(addr)); err != nil {
+		return "", err
+	}
+	ptr, n := readPtr(order, ptrSize, hdr), readPtr(order, ptrSize, hdr[ptrSize:])
+	if n > maxBuildInfoSize {
+		return "", fmt.Errorf("string too large: %d", n)
+	}
+	data := make([]byte, n)
+	if err := r.ReadAt(data, int(ptr)); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func readPtr(order binary.ByteOrder, ptrSize int, data []byte) uint64 {
+	if ptrSize == 4 {
+		return uint64(order.Uint32(data))
+	}
+	return order.Uint64(data)
+}
+
+func readVarintString(data []byte) (string, []byte, bool) {
+	n, w := binary.Uvarint(data)
+	if w <= 0 || n > uint64(len(data)-w) {
+		return "", nil, false
+	}
+	return string(data[w : w+int(n)]), data[w+int(n):], true
+}
@@ -0,0 +1,84 @@
+package elf
+
+import (
+	"debug/buildinfo"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMMapedElfFile_GoBuildInfo(t *testing.T) {
+	testcases := []struct {
+		f       string
+		version string
+	}{
+		{"./testdata/elfs/go16", "go1.16.15"},
+		{"./testdata/elfs/go16-static", "go1.16.15"},
+		{"./testdata/elfs/go18", "go1.18.10"},
+		{"./testdata/elfs/go18-static", "go1.18.10"},
+		{"./testdata/elfs/go20", "go1.20.5"},
+		{"./testdata/elfs/go20-static", "go1.20.5"},
+		{"./testdata/elfs/go21-386", "go1.21.13"},
+		{"./testdata/elfs/go21-s390x", "go1.21.13"},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.f, func(t *testing.T) {
+			expected, err := buildinfo.ReadFile(tt.f)
+			require.NoError(t, err)
+
+			me, err := NewMMapedElfFile(tt.f)
+			require.NoError(t, err)
+			defer me.Close()
+
+			bi, err := me.GoBuildInfo()
+			require.NoError(t, err)
+			assert.Equal(t, tt.version, bi.GoVersion)
+			assert.Equal(t, "command-line-arguments", bi.Path)
+			assert.Equal(t, expected, bi)
+		})
+	}
+
+	t.Run("settings", func(t *testing.T) {
+		me, err := NewMMapedElfFile("./testdata/elfs/go20")
+		require.NoError(t, err)
+		defer me.Close()
+		bi, err := me.GoBuildInfo()
+		require.NoError(t, err)
+		settings := map[string]string{}
+		for _, s := range bi.Settings {
+			settings[s.Key] = s.Value
+		}
+		assert.Equal(t, "amd64", settings["GOARCH"])
+		assert.Equal(t, "linux", settings["GOOS"])
+	})
+
+	t.Run("no section headers", func(t *testing.T) {
+		me, err := NewMMapedElfFile(stripSectionHeaders(t, "./testdata/elfs/go20"))
+		require.NoError(t, err)
+		defer me.Close()
+		bi, err := me.GoBuildInfo()
+		require.NoError(t, err)
+		assert.Equal(t, "go1.20.5", bi.GoVersion)
+	})
+
+	t.Run("no .go.buildinfo", func(t *testing.T) {
+		// the segments are only searched when section headers are missing
+		me, err := NewMMapedElfFile("./testdata/elfs/go20")
+		require.NoError(t, err)
+		defer me.Close()
+		me.Section(".go.buildinfo").Name = ""
+		_, err = me.GoBuildInfo()
+		require.ErrorIs(t, err, ErrNoBuildInfo)
+	})
+
+	for _, f := range []string{"./testdata/elfs/go12", "./testdata/elfs/elf"} {
+		t.Run(f, func(t *testing.T) {
+			me, err := NewMMapedElfFile(f)
+			require.NoError(t, err)
+			defer me.Close()
+			_, err = me.GoBuildInfo()
+			require.ErrorIs(t, err, ErrNoBuildInfo)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package elf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed sections are written by `objcopy --compress-debug-sections` or
+// `gcc -gz`. SHF_COMPRESSED sections start with an Elf_Chdr, legacy .zdebug_*
+// sections with "ZLIB" and the big endian uncompressed size.
+
+// maxDecompressedSize bounds the memory of a single decompressed section.
+const maxDecompressedSize = 1 << 30
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+})
+
+func isZDebug(s *elf.SectionHeader) bool {
+	return strings.HasPrefix(s.Name, ".zdebug_")
+}
+
+// debugSection returns the .debug_* section name, or its legacy compressed
+// .zdebug_* counterpart.
+func (f *MMapedElfFile) debugSection(name string) *elf.SectionHeader {
+	if s := f.Section(name); s != nil {
+		return s
+	}
+	if strings.HasPrefix(name, ".debug_") {
+		return f.Section(".z" + name[1:])
+	}
+	return nil
+}
+
+// decompressSection decompresses the raw contents of a compressed section.
+func (f *MMapedElfFile) decompressSection(s *elf.SectionHeader, raw []byte) ([]byte, error) {
+	if isZDebug(s) {
+		if len(raw) < 12 || string(raw[:4]) != "ZLIB" {
+			return nil, fmt.Errorf("%s: invalid .zdebug header", s.Name)
+		}
+		return decompress(elf.COMPRESS_ZLIB, binary.BigEndian.Uint64(raw[4:]), raw[12:])
+	}
+
+	var (
+		typ  elf.CompressionType
+		size uint64
+		hdr  int
+	)
+	switch f.Class {
+	case elf.ELFCLASS64:
+		// ch_type, ch_reserved, ch_size, ch_addralign
+		if len(raw) < 24 {
+			return nil, fmt.Errorf("%s: short compression header", s.Name)
+		}
+		typ, size, hdr = elf.CompressionType(f.ByteOrder.Uint32(raw)), f.ByteOrder.Uint64(raw[8:]), 24
+	case elf.ELFCLASS32:
+		// ch_type, ch_size, ch_addralign
+		if len(raw) < 12 {
+			return nil, fmt.Errorf("%s: short compression header", s.Name)
+		}
+		typ, size, hdr = elf.CompressionType(f.ByteOrder.Uint32(raw)), uint64(f.ByteOrder.Uint32(raw[4:])), 12
+	default:
+		return nil, fmt.Errorf("%s: unknown elf class %v", s.Name, f.Class)
+	}
+	return decompress(typ, size, raw[hdr:])
+}
+
+func decompress(typ elf.CompressionType, size uint64, data []byte) ([]byte, error) {
+	if size > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed size %d too large", size)
+	}
+	res := make([]byte, size)
+	switch typ {
+	case elf.COMPRESS_ZLIB:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zlib: %w", err)
+		}
+		if _, err = io.ReadFull(r, res); err != nil {
+			return nil, fmt.Errorf("zlib: %w", err)
+		}
+	case elf.COMPRESS_ZSTD:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		out, err := dec.DecodeAll(data, res[:0])
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		if uint64(len(out)) != size {
+			return nil, fmt.Errorf("zstd: decompressed %d bytes, expected %d", len(out), size)
+		}
+		res = out
+	default:
+		return nil, fmt.Errorf("unsupported compression type %v", typ)
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package elf
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionData_Compressed(t *testing.T) {
+	plain, err := NewMMapedElfFile("./testdata/elfs/elf.dwarf.debug")
+	require.NoError(t, err)
+	defer plain.Close()
+
+	testcases := []struct {
+		fpath   string
+		section string
+	}{
+		{"./testdata/elfs/elf.dwarf.debug.zlib", ".debug_info"},
+		{"./testdata/elfs/elf.dwarf.debug.zstd", ".debug_info"},
+		{"./testdata/elfs/elf.dwarf.debug.zlib-gnu", ".zdebug_info"},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.fpath, func(t *testing.T) {
+			me, err := NewMMapedElfFile(tt.fpath)
+			require.NoError(t, err)
+			defer me.Close()
+			require.NotNil(t, me.Section(tt.section))
+
+			for _, name := range []string{".debug_info", ".debug_line", ".debug_abbrev"} {
+				expected, err := plain.GetSectionData(name)
+				require.NoError(t, err)
+				data, err := me.GetSectionData(name)
+				require.NoError(t, err, name)
+				assert.Equal(t, expected.Data, data.Data, name)
+			}
+		})
+	}
+}
+
+func TestSectionData_CompressedInvalid(t *testing.T) {
+	f := &MMapedElfFile{FileHeader: elf.FileHeader{Class: elf.ELFCLASS64}}
+	_, err := f.decompressSection(&elf.SectionHeader{Name: ".zdebug_info"}, []byte("ZLIX"))
+	assert.Error(t, err)
+	_, err = f.decompressSection(&elf.SectionHeader{Name: ".debug_info"}, make([]byte, 8))
+	assert.Error(t, err)
+}
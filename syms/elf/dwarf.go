@@ -0,0 +1,377 @@
+package elf
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// DwarfTable resolves addresses to source file and line using the
+// .debug_line program of the compile unit covering the address, and expands
+// inlined calls using the DW_TAG_inlined_subroutine tree of .debug_info.
+// Both are decoded lazily, once per compile unit, and read without locking:
+// the table is safe for concurrent use.
+type DwarfTable struct {
+	data   *dwarf.Data
+	units  []*dwarfUnit
+	ranges []dwarfRange // sorted by low
+
+	demangleOptions []demangle.Option
+	keepRustHash    bool
+	// names of the abstract origins and specifications by offset
+	names sync.Map
+	// size of the sections read
+	dataSize int64
+}
+
+// Frame is a logical frame of an address. Each function inlined at the
+// address gets its own frame.
+type Frame struct {
+	Name string
+	File string
+	Line int
+}
+
+type dwarfRange struct {
+	low, high uint64
+	unit      *dwarfUnit
+	fn        *dwarfFunc
+}
+
+type dwarfUnit struct {
+	entry     *dwarf.Entry
+	linesOnce sync.Once
+	files     []string
+	rows      []lineRow // sorted by addr
+	// file table of the line program, indexed by DW_AT_call_file
+	fileTable []*dwarf.LineFile
+
+	funcsOnce sync.Once
+	funcs     []dwarfRange // top level subprograms, sorted by low
+}
+
+// dwarfFunc is a subprogram or an inlined subroutine instance.
+type dwarfFunc struct {
+	ranges   [][2]uint64
+	name     string
+	callFile int64
+	callLine int64
+	inlined  []*dwarfFunc
+}
+
+func (fn *dwarfFunc) contains(addr uint64) bool {
+	for _, r := range fn.ranges {
+		if addr >= r[0] && addr < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+type lineRow struct {
+	addr uint64
+	file int32
+	line int32
+	end  bool
+}
+
+var errNoDwarf = errors.New("no dwarf debug info")
+
+// sections consumed by dwarf.New, in argument order
+var dwarfSections = []string{"abbrev", "aranges", "frame", "info", "line", "pubnames", "ranges", "str"}
+
+// DWARF 5 sections, passed with dwarf.Data.AddSection
+var dwarfSections5 = []string{"addr", "line_str", "str_offsets", "rnglists"}
+
+func (f *MMapedElfFile) NewDwarfTable(opt *SymbolOptions) (*DwarfTable, error) {
+	if f.debugSection(".debug_info") == nil || f.debugSection(".debug_line") == nil {
+		return nil, errNoDwarf
+	}
+	var dataSize int64
+	read := func(name string) []byte {
+		data, err := f.GetSectionData(".debug_" + name)
+		if err != nil || data.Header.Type == elf.SHT_NOBITS {
+			return nil
+		}
+		dataSize += int64(len(data.Data))
+		return data.Data
+	}
+	var dat [8][]byte
+	for i, name := range dwarfSections {
+		dat[i] = read(name)
+	}
+	data, err := dwarf.New(dat[0], dat[1], dat[2], dat[3], dat[4], dat[5], dat[6], dat[7])
+	if err != nil {
+		return nil, fmt.Errorf("dwarf: %w", err)
+	}
+	for _, name := range dwarfSections5 {
+		b := read(name)
+		if b == nil {
+			continue
+		}
+		if err = data.AddSection(".debug_"+name, b); err != nil {
+			return nil, fmt.Errorf("dwarf add section %s: %w", name, err)
+		}
+	}
+
+	res := &DwarfTable{
+		data:            data,
+		demangleOptions: opt.DemangleOpts,
+		keepRustHash:    opt.KeepRustHash,
+		dataSize:        dataSize,
+	}
+	if err = res.index(); err != nil {
+		return nil, err
+	}
+	if len(res.ranges) == 0 {
+		return nil, errNoDwarf
+	}
+	return res, nil
+}
+
+// MemSize estimates the memory of the table: the DWARF sections and the
+// compile unit ranges. Line tables decoded later are not accounted for.
+func (d *DwarfTable) MemSize() int64 {
+	return d.dataSize + int64(len(d.ranges))*int64(unsafe.Sizeof(dwarfRange{}))
+}
+
+// index collects the address ranges of every compile unit.
+func (d *DwarfTable) index() error {
+	r := d.data.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return fmt.Errorf("dwarf read unit: %w", err)
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		r.SkipChildren()
+		ranges, err := d.data.Ranges(entry)
+		if err != nil || len(ranges) == 0 {
+			continue
+		}
+		unit := &dwarfUnit{entry: entry}
+		d.units = append(d.units, unit)
+		for _, rng := range ranges {
+			if rng[0] >= rng[1] {
+				continue
+			}
+			d.ranges = append(d.ranges, dwarfRange{low: rng[0], high: rng[1], unit: unit})
+		}
+	}
+	sort.Slice(d.ranges, func(i, j int) bool { return d.ranges[i].low < d.ranges[j].low })
+	return nil
+}
+
+func (d *DwarfTable) findUnit(addr uint64) *dwarfUnit {
+	i := sort.Search(len(d.ranges), func(i int) bool { return addr < d.ranges[i].low })
+	if i == 0 || addr >= d.ranges[i-1].high {
+		return nil
+	}
+	return d.ranges[i-1].unit
+}
+
+func (d *DwarfTable) findFunc(unit *dwarfUnit, addr uint64) *dwarfFunc {
+	unit.funcsOnce.Do(func() { d.loadFuncs(unit) })
+	i := sort.Search(len(unit.funcs), func(i int) bool { return addr < unit.funcs[i].low })
+	if i == 0 || addr >= unit.funcs[i-1].high {
+		return nil
+	}
+	return unit.funcs[i-1].fn
+}
+
+// ResolveFrames returns the logical frames of addr, innermost first. The
+// innermost frame carries the line of addr itself, every outer frame the
+// line of the call site that was inlined. A single frame is returned for
+// addresses without inlined calls, nil for addresses without debug info.
+func (d *DwarfTable) ResolveFrames(addr uint64) []Frame {
+	unit := d.findUnit(addr)
+	if unit == nil {
+		return nil
+	}
+	file, line := d.ResolveLine(addr)
+	fn := d.findFunc(unit, addr)
+	if fn == nil {
+		return []Frame{{File: file, Line: line}}
+	}
+	chain := []*dwarfFunc{fn}
+	for {
+		var next *dwarfFunc
+		for _, inl := range fn.inlined {
+			if inl.contains(addr) {
+				next = inl
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+		fn = next
+	}
+	frames := make([]Frame, len(chain))
+	for i := range chain {
+		fn := chain[len(chain)-1-i]
+		frames[i] = Frame{Name: fn.name, File: file, Line: line}
+		file, line = unit.callFile(fn.callFile), int(fn.callLine)
+	}
+	return frames
+}
+
+func (u *dwarfUnit) callFile(idx int64) string {
+	if idx < 0 || idx >= int64(len(u.fileTable)) || u.fileTable[idx] == nil {
+		return ""
+	}
+	return u.fileTable[idx].Name
+}
+
+// loadFuncs builds the tree of subprograms and inlined subroutines of unit.
+func (d *DwarfTable) loadFuncs(unit *dwarfUnit) {
+	unit.linesOnce.Do(func() { d.loadLines(unit) })
+	r := d.data.Reader()
+	r.Seek(unit.entry.Offset)
+	if cu, err := r.Next(); err != nil || cu == nil || !cu.Children {
+		return
+	}
+	// enclosing function of each nesting level, nil outside functions
+	stack := []*dwarfFunc{nil}
+	for len(stack) > 0 {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			break
+		}
+		if entry.Tag == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		parent := stack[len(stack)-1]
+		fn := parent
+		if entry.Tag == dwarf.TagSubprogram || entry.Tag == dwarf.TagInlinedSubroutine {
+			if ranges, _ := d.data.Ranges(entry); len(ranges) > 0 {
+				fn = &dwarfFunc{ranges: ranges, name: d.funcName(entry, 0)}
+				fn.callFile, _ = entry.Val(dwarf.AttrCallFile).(int64)
+				fn.callLine, _ = entry.Val(dwarf.AttrCallLine).(int64)
+				if parent != nil && entry.Tag == dwarf.TagInlinedSubroutine {
+					parent.inlined = append(parent.inlined, fn)
+				} else if entry.Tag == dwarf.TagSubprogram {
+					for _, rng := range ranges {
+						unit.funcs = append(unit.funcs, dwarfRange{low: rng[0], high: rng[1], unit: unit, fn: fn})
+					}
+				}
+			}
+		}
+		if entry.Children {
+			stack = append(stack, fn)
+		}
+	}
+	sort.Slice(unit.funcs, func(i, j int) bool { return unit.funcs[i].low < unit.funcs[j].low })
+}
+
+// funcName returns the name of a subprogram entry, following abstract
+// origins and specifications. Linkage names are preferred and demangled.
+func (d *DwarfTable) funcName(entry *dwarf.Entry, depth int) string {
+	if entry == nil || depth > 8 {
+		return ""
+	}
+	if name, ok := entry.Val(dwarf.AttrLinkageName).(string); ok {
+		if len(d.demangleOptions) > 0 {
+			name = demangleName(name, d.demangleOptions, d.keepRustHash)
+		}
+		return name
+	}
+	if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+		return name
+	}
+	off, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+	if !ok {
+		if off, ok = entry.Val(dwarf.AttrSpecification).(dwarf.Offset); !ok {
+			return ""
+		}
+	}
+	if name, ok := d.names.Load(off); ok {
+		return name.(string)
+	}
+	r := d.data.Reader()
+	r.Seek(off)
+	origin, err := r.Next()
+	if err != nil {
+		return ""
+	}
+	name := d.funcName(origin, depth+1)
+	d.names.Store(off, name)
+	return name
+}
+
+// ResolveLine returns the source file and line of addr, or an empty file
+// name if addr is not covered by any line program.
+func (d *DwarfTable) ResolveLine(addr uint64) (string, int) {
+	unit := d.findUnit(addr)
+	if unit == nil {
+		return "", 0
+	}
+	unit.linesOnce.Do(func() { d.loadLines(unit) })
+	rows := unit.rows
+	i := sort.Search(len(rows), func(i int) bool { return addr < rows[i].addr })
+	if i == 0 {
+		return "", 0
+	}
+	row := &rows[i-1]
+	if row.end || row.file < 0 || int(row.file) >= len(unit.files) {
+		return "", 0
+	}
+	return unit.files[row.file], int(row.line)
+}
+
+func (d *DwarfTable) loadLines(unit *dwarfUnit) {
+	lr, err := d.data.LineReader(unit.entry)
+	if err != nil || lr == nil {
+		return
+	}
+	files := make(map[*dwarf.LineFile]int32)
+	var entry dwarf.LineEntry
+	for {
+		if err = lr.Next(&entry); err != nil {
+			break
+		}
+		var file int32 = -1
+		if entry.File != nil {
+			idx, ok := files[entry.File]
+			if !ok {
+				idx = int32(len(unit.files))
+				files[entry.File] = idx
+				unit.files = append(unit.files, entry.File.Name)
+			}
+			file = idx
+		}
+		unit.rows = append(unit.rows, lineRow{
+			addr: entry.Address,
+			file: file,
+			line: int32(entry.Line),
+			end:  entry.EndSequence,
+		})
+	}
+	unit.fileTable = lr.Files()
+	// Sequences are not required to be emitted in address order. An
+	// end_sequence row sorts ahead of a sequence starting at the same address,
+	// so the lookup picks the real row.
+	sort.SliceStable(unit.rows, func(i, j int) bool {
+		a, b := &unit.rows[i], &unit.rows[j]
+		if a.addr == b.addr {
+			return a.end && !b.end
+		}
+		return a.addr < b.addr
+	})
+}
+
+func (d *DwarfTable) Size() int { return len(d.units) }
@@ -0,0 +1,150 @@
+package elf
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDwarfTable_ResolveLine(t *testing.T) {
+	testcases := []struct {
+		fpath string
+		sym   string
+		line  int
+	}{
+		{"./testdata/elfs/elf.dwarf", "iter", 9},
+		{"./testdata/elfs/elf.dwarf", "main", 13},
+		{"./testdata/elfs/elf.dwarf.debug", "iter", 9},
+		{"./testdata/elfs/elf.dwarf.debug", "main", 13},
+		{"./testdata/elfs/elf.dwarf.debug.zlib", "main", 13},
+		{"./testdata/elfs/elf.dwarf.debug.zlib-gnu", "main", 13},
+		{"./testdata/elfs/elf.dwarf.debug.zstd", "main", 13},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.fpath+"/"+tt.sym, func(t *testing.T) {
+			addr := lookupSymbol(t, tt.fpath, tt.sym)
+
+			me, err := NewMMapedElfFile(tt.fpath)
+			require.NoError(t, err)
+			defer me.Close()
+
+			tab, err := me.NewDwarfTable(new(SymbolOptions))
+			require.NoError(t, err)
+
+			file, line := tab.ResolveLine(addr)
+			assert.Equal(t, "src.c", filepath.Base(file))
+			assert.Equal(t, tt.line, line)
+
+			file, line = tab.ResolveLine(0)
+			assert.Empty(t, file)
+			assert.Zero(t, line)
+		})
+	}
+}
+
+func TestDwarfTable_ResolveFrames(t *testing.T) {
+	const fpath = "./testdata/elfs/elf.inline"
+	me, err := NewMMapedElfFile(fpath)
+	require.NoError(t, err)
+	defer me.Close()
+
+	tab, err := me.NewDwarfTable(new(SymbolOptions))
+	require.NoError(t, err)
+
+	outer := lookupSymbol(t, fpath, "outer")
+	testcases := []struct {
+		addr   uint64
+		frames []Frame
+	}{
+		{outer, []Frame{{"outer", "inline.c", 11}}},
+		{outer + 0x10, []Frame{{"middle", "inline.c", 8}, {"outer", "inline.c", 12}}},
+		{outer + 0x1b, []Frame{{"leaf", "inline.c", 4}, {"middle", "inline.c", 8}, {"outer", "inline.c", 12}}},
+		{lookupSymbol(t, fpath, "main"), []Frame{{"main", "inline.c", 15}}},
+		{0, nil},
+	}
+	for _, tt := range testcases {
+		frames := tab.ResolveFrames(tt.addr)
+		for i := range frames {
+			frames[i].File = filepath.Base(frames[i].File)
+		}
+		assert.Equal(t, tt.frames, frames, "addr 0x%x", tt.addr)
+	}
+}
+
+func TestDwarfTable_Concurrent(t *testing.T) {
+	const fpath = "./testdata/elfs/elf.inline"
+	me, err := NewMMapedElfFile(fpath)
+	require.NoError(t, err)
+	defer me.Close()
+	serial, err := me.NewDwarfTable(new(SymbolOptions))
+	require.NoError(t, err)
+	tab, err := me.NewDwarfTable(new(SymbolOptions))
+	require.NoError(t, err)
+
+	outer := lookupSymbol(t, fpath, "outer")
+	frames := make([][]Frame, 0x40)
+	for i := range frames {
+		frames[i] = serial.ResolveFrames(outer + uint64(i))
+	}
+	require.NotEmpty(t, frames[0x1b])
+
+	// units are loaded by the first readers
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range frames {
+				assert.Equal(t, frames[i], tab.ResolveFrames(outer+uint64(i)))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkDwarfTable_ResolveFrames resolves the frames of the addresses of
+// the inlined functions from concurrent goroutines.
+func BenchmarkDwarfTable_ResolveFrames(b *testing.B) {
+	const fpath = "./testdata/elfs/elf.inline"
+	me, err := NewMMapedElfFile(fpath)
+	require.NoError(b, err)
+	defer me.Close()
+	tab, err := me.NewDwarfTable(new(SymbolOptions))
+	require.NoError(b, err)
+	outer := lookupSymbol(b, fpath, "outer")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			tab.ResolveFrames(outer + uint64(i%0x40))
+		}
+	})
+}
+
+func TestDwarfTable_NoDebugInfo(t *testing.T) {
+	me, err := NewMMapedElfFile("./testdata/elfs/elf")
+	require.NoError(t, err)
+	defer me.Close()
+
+	_, err = me.NewDwarfTable(new(SymbolOptions))
+	require.ErrorIs(t, err, errNoDwarf)
+}
+
+func lookupSymbol(t testing.TB, fpath, name string) uint64 {
+	t.Helper()
+	e, err := elf.Open(fpath)
+	require.NoError(t, err)
+	defer e.Close()
+	symbols, err := e.Symbols()
+	require.NoError(t, err)
+	for _, sym := range symbols {
+		if sym.Name == name {
+			return sym.Value
+		}
+	}
+	t.Fatalf("symbol %s not found in %s", name, fpath)
+	return 0
+}
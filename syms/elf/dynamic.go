@@ -0,0 +1,161 @@
+package elf
+
+import (
+	"debug/elf"
+	"io"
+)
+
+// Binaries without section headers still describe their dynamic symbols in
+// the PT_DYNAMIC segment, which the dynamic loader uses. dynamicSections
+// rebuilds the .dynsym and .dynstr headers from it so that symbols can be
+// read as usual.
+
+// dynamicSections returns synthesized section headers: the null section,
+// .dynstr and .dynsym. It returns nil if there is no usable dynamic segment.
+func (f *MMapedElfFile) dynamicSections(r io.ReaderAt) []elf.SectionHeader {
+	var dyn *elf.ProgHeader
+	for i := range f.Progs {
+		if f.Progs[i].Type == elf.PT_DYNAMIC {
+			dyn = &f.Progs[i]
+			break
+		}
+	}
+	if dyn == nil || dyn.Filesz > 1<<20 {
+		return nil
+	}
+	data := make([]byte, dyn.Filesz)
+	if _, err := r.ReadAt(data, int64(dyn.Off)); err != nil {
+		return nil
+	}
+
+	entSize, symSize := 16, uint64(elf.Sym64Size)
+	if f.Class == elf.ELFCLASS32 {
+		entSize, symSize = 8, elf.Sym32Size
+	}
+	var symtab, strtab, strsz, hash, gnuHash uint64
+loop:
+	for ; len(data) >= entSize; data = data[entSize:] {
+		var tag elf.DynTag
+		var val uint64
+		if f.Class == elf.ELFCLASS32 {
+			tag, val = elf.DynTag(int32(f.ByteOrder.Uint32(data))), uint64(f.ByteOrder.Uint32(data[4:]))
+		} else {
+			tag, val = elf.DynTag(int64(f.ByteOrder.Uint64(data))), f.ByteOrder.Uint64(data[8:])
+		}
+		switch tag {
+		case elf.DT_NULL:
+			break loop
+		case elf.DT_SYMTAB:
+			symtab = val
+		case elf.DT_STRTAB:
+			strtab = val
+		case elf.DT_STRSZ:
+			strsz = val
+		case elf.DT_SYMENT:
+			symSize = val
+		case elf.DT_HASH:
+			hash = val
+		case elf.DT_GNU_HASH:
+			gnuHash = val
+		}
+	}
+	if symtab == 0 || strtab == 0 || strsz == 0 {
+		return nil
+	}
+
+	var nsyms uint64
+	switch {
+	case hash != 0:
+		// nbucket, nchain: there is a chain entry per symbol
+		var buf [4]byte
+		if err := f.readVaddr(r, buf[:], hash+4); err != nil {
+			return nil
+		}
+		nsyms = uint64(f.ByteOrder.Uint32(buf[:]))
+	case gnuHash != 0:
+		nsyms = f.gnuHashSymbols(r, gnuHash)
+	}
+	symOff, ok := f.addrOffset(symtab)
+	strOff, ok2 := f.addrOffset(strtab)
+	if nsyms == 0 || !ok || !ok2 {
+		return nil
+	}
+	return []elf.SectionHeader{
+		{},
+		{
+			Name:     ".dynstr",
+			Type:     elf.SHT_STRTAB,
+			Flags:    elf.SHF_ALLOC,
+			Addr:     strtab,
+			Offset:   strOff,
+			Size:     strsz,
+			FileSize: strsz,
+		},
+		{
+			Name:     ".dynsym",
+			Type:     elf.SHT_DYNSYM,
+			Flags:    elf.SHF_ALLOC,
+			Addr:     symtab,
+			Offset:   symOff,
+			Size:     nsyms * symSize,
+			FileSize: nsyms * symSize,
+			Link:     1,
+			Entsize:  symSize,
+		},
+	}
+}
+
+// gnuHashSymbols returns the number of dynamic symbols, one past the last
+// symbol reachable from the DT_GNU_HASH table.
+func (f *MMapedElfFile) gnuHashSymbols(r io.ReaderAt, addr uint64) uint64 {
+	// nbuckets, symoffset, bloom_size, bloom_shift, bloom[bloom_size],
+	// buckets[nbuckets], chain[]
+	var hdr [16]byte
+	if err := f.readVaddr(r, hdr[:], addr); err != nil {
+		return 0
+	}
+	nbuckets := uint64(f.ByteOrder.Uint32(hdr[0:]))
+	symoffset := uint64(f.ByteOrder.Uint32(hdr[4:]))
+	bloomSize := uint64(f.ByteOrder.Uint32(hdr[8:]))
+	wordSize := uint64(8)
+	if f.Class == elf.ELFCLASS32 {
+		wordSize = 4
+	}
+	if nbuckets > 1<<24 {
+		return 0
+	}
+	buckets := make([]byte, nbuckets*4)
+	bucketsAddr := addr + 16 + bloomSize*wordSize
+	if err := f.readVaddr(r, buckets, bucketsAddr); err != nil {
+		return 0
+	}
+	var last uint64
+	for i := uint64(0); i < nbuckets; i++ {
+		last = max(last, uint64(f.ByteOrder.Uint32(buckets[i*4:])))
+	}
+	if last < symoffset {
+		return symoffset
+	}
+	// the chain of the last bucket ends with an entry with the low bit set
+	chain := bucketsAddr + nbuckets*4
+	var buf [4]byte
+	for i := 0; i < 1<<24; i++ {
+		if err := f.readVaddr(r, buf[:], chain+(last-symoffset)*4); err != nil {
+			return 0
+		}
+		if f.ByteOrder.Uint32(buf[:])&1 != 0 {
+			return last + 1
+		}
+		last++
+	}
+	return 0
+}
+
+func (f *MMapedElfFile) readVaddr(r io.ReaderAt, data []byte, addr uint64) error {
+	off, ok := f.addrOffset(addr)
+	if !ok {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := r.ReadAt(data, int64(off))
+	return err
+}
@@ -0,0 +1,61 @@
+package elf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolTable_NoSections(t *testing.T) {
+	testcases := []struct {
+		fpath   string
+		buildID string
+	}{
+		{"./testdata/elfs/elf.nosections", "56cae3c52cd67f2ee3914b6aaccf92130925c1d4"},      // DT_GNU_HASH
+		{"./testdata/elfs/elf.nosections.sysv", "cf2b74cdc999e2b94830fbdb950aeb8d98a312fa"}, // DT_HASH
+	}
+	for _, tt := range testcases {
+		t.Run(filepath.Base(tt.fpath), func(t *testing.T) {
+			me, err := NewMMapedElfFile(tt.fpath)
+			require.NoError(t, err)
+			defer me.Close()
+			require.NotNil(t, me.Section(".dynsym"))
+			require.NotNil(t, me.Section(".dynstr"))
+
+			tab, err := me.NewSymbolTable(new(SymbolOptions))
+			require.NoError(t, err)
+			assert.Equal(t, 1, tab.Size())
+			assert.Equal(t, "lib_iter", tab.Resolve(0x1119))
+
+			id, err := me.BuildId()
+			require.NoError(t, err)
+			assert.Equal(t, GNUBuildId(tt.buildID), id)
+		})
+	}
+}
+
+func TestGoBuildID_NoSections(t *testing.T) {
+	me, err := NewMMapedElfFile(stripSectionHeaders(t, "./testdata/elfs/go20"))
+	require.NoError(t, err)
+	defer me.Close()
+	assert.Empty(t, me.Sections)
+
+	id, err := me.BuildId()
+	require.NoError(t, err)
+	assert.Equal(t, GoBuildId("Qffu__H4fOgskLcG9xgZ/IJ9VzAiPxBstzejlZLmK/EGJHHzTL5Vs7GGklz10L/r6shgckObuxZ4kbw9YGX"), id)
+}
+
+// stripSectionHeaders writes a copy of a 64-bit little endian file with
+// e_shoff, e_shnum and e_shstrndx cleared.
+func stripSectionHeaders(t *testing.T, fpath string) string {
+	data, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+	clear(data[0x28:0x30])
+	clear(data[0x3c:0x40])
+	res := filepath.Join(t.TempDir(), filepath.Base(fpath))
+	require.NoError(t, os.WriteFile(res, data, 0o644))
+	return res
+}
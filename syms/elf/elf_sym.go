@@ -23,16 +23,21 @@ import (
 	"debug/elf"
 	"errors"
 	"fmt"
-	"unsafe"
 
 	"github.com/ianlancetaylor/demangle"
 )
 
 type SymbolOptions struct {
 	DemangleOpts []demangle.Option
+	// KeepRustHash keeps the trailing hash of demangled Rust legacy names,
+	// which tells apart the instances of a generic function.
+	KeepRustHash bool
 	// ignore symbols from FilterFrom to FilterTo
 	FilterFrom uint64
 	FilterTo   uint64
+	// DebugFile is the separate debug file of the file, its .symtab is used
+	// instead of the one of the file, if any.
+	DebugFile *MMapedElfFile
 }
 
 // todo consider using ReaderAt here, same as in gopcln
@@ -82,10 +87,10 @@ func (f *MMapedElfFile) getSymbols64(typ elf.SectionType, opt *SymbolOptions) ([
 	for len(data) > 0 {
 		raw := data[:elf.Sym64Size]
 		data = data[elf.Sym64Size:]
-		sym := (*elf.Sym64)(unsafe.Pointer(&raw[0]))
+		sym := f.decodeSym64(raw)
 
 		if sym.Value != 0 && sym.Info&0xf == byte(elf.STT_FUNC) {
-			if sym.Name >= 0x7fffffff {
+			if sym.Name > maxNameIndex {
 				return nil, 0, fmt.Errorf("wrong sym name")
 			}
 			pc := sym.Value
@@ -93,6 +98,7 @@ func (f *MMapedElfFile) getSymbols64(typ elf.SectionType, opt *SymbolOptions) ([
 				continue
 			}
 			symbols[i].Value = pc
+			symbols[i].Size = sym.Size
 			symbols[i].Name = NewName(sym.Name, linkIndex)
 			i++
 		}
@@ -119,7 +125,7 @@ func (f *MMapedElfFile) getSymbols32(typ elf.SectionType, opt *SymbolOptions) ([
 	}
 
 	if len(data)%elf.Sym32Size != 0 {
-		return nil, 0, errors.New("length of symbol section is not a multiple of Sym64Size")
+		return nil, 0, errors.New("length of symbol section is not a multiple of Sym32Size")
 	}
 
 	// The first entry is all zeros.
@@ -131,15 +137,17 @@ func (f *MMapedElfFile) getSymbols32(typ elf.SectionType, opt *SymbolOptions) ([
 	for len(data) > 0 {
 		raw := data[:elf.Sym32Size]
 		data = data[elf.Sym32Size:]
-		sym := (*elf.Sym32)(unsafe.Pointer(&raw[0]))
+		sym := f.decodeSym32(raw)
 		if sym.Value != 0 && sym.Info&0xf == byte(elf.STT_FUNC) {
-			if sym.Name >= 0x7fffffff {
+			if sym.Name > maxNameIndex {
 				return nil, 0, fmt.Errorf("wrong sym name")
 			}
 			pc := uint64(sym.Value)
 			if pc >= opt.FilterFrom && pc < opt.FilterTo {
 				continue
 			}
+			symbols[i].Value = pc
+			symbols[i].Size = uint64(sym.Size)
 			symbols[i].Name = NewName(sym.Name, linkIndex)
 			i++
 		}
@@ -147,3 +155,28 @@ func (f *MMapedElfFile) getSymbols32(typ elf.SectionType, opt *SymbolOptions) ([
 
 	return symbols[:i], symtabSection.Link, nil
 }
+
+// decodeSym64 decodes an Elf64_Sym in the byte order of the file, which may
+// differ from the host one.
+func (f *MMapedElfFile) decodeSym64(raw []byte) elf.Sym64 {
+	return elf.Sym64{
+		Name:  f.ByteOrder.Uint32(raw[0:4]),
+		Info:  raw[4],
+		Other: raw[5],
+		Shndx: f.ByteOrder.Uint16(raw[6:8]),
+		Value: f.ByteOrder.Uint64(raw[8:16]),
+		Size:  f.ByteOrder.Uint64(raw[16:24]),
+	}
+}
+
+// decodeSym32 decodes an Elf32_Sym in the byte order of the file.
+func (f *MMapedElfFile) decodeSym32(raw []byte) elf.Sym32 {
+	return elf.Sym32{
+		Name:  f.ByteOrder.Uint32(raw[0:4]),
+		Value: f.ByteOrder.Uint32(raw[4:8]),
+		Size:  f.ByteOrder.Uint32(raw[8:12]),
+		Info:  raw[12],
+		Other: raw[13],
+		Shndx: f.ByteOrder.Uint16(raw[14:16]),
+	}
+}
@@ -4,23 +4,37 @@ import (
 	"bytes"
 	"debug/elf"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ianlancetaylor/demangle"
+	"golang.org/x/sys/unix"
 )
 
+// MMapedElfFile is safe for concurrent reads, the file is reopened once
+// after Close. Close must not be called concurrently with reads.
 type MMapedElfFile struct {
 	elf.FileHeader
 	Sections []elf.SectionHeader
 	Progs    []elf.ProgHeader
 
 	fpath string
-	err   error
-	fd    *os.File
+	// mu serializes opening the file, readers check opened first
+	mu     sync.Mutex
+	opened atomic.Bool
+	err    error
+	fd     *os.File
+	// contents of the file, mapped from fd when mapped is set, otherwise an
+	// in-memory file (see NewMemElfFile). nil for files read with fd.ReadAt.
+	data   []byte
+	mapped bool
 
-	stringCache map[int]string
+	// demangled strings by offset
+	stringCache sync.Map
 }
 
 func NewMMapedElfFile(fpath string) (*MMapedElfFile, error) {
@@ -32,11 +46,33 @@ func NewMMapedElfFile(fpath string) (*MMapedElfFile, error) {
 		res.Close()
 		return nil, err
 	}
-	elfFile, err := elf.NewFile(res.fd)
-	if err != nil {
+	if err = res.readHeaders(res.fd); err != nil {
 		res.Close()
 		return nil, err
 	}
+	runtime.SetFinalizer(res, func(obj *MMapedElfFile) { obj.Finalize() })
+	return res, nil
+}
+
+// NewMemElfFile opens an ELF file held in memory, such as the MiniDebugInfo
+// embedded in .gnu_debugdata. name is only used for reporting.
+func NewMemElfFile(name string, data []byte) (*MMapedElfFile, error) {
+	res := &MMapedElfFile{
+		fpath: name,
+		data:  data,
+	}
+	if err := res.readHeaders(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	res.opened.Store(true)
+	return res, nil
+}
+
+func (f *MMapedElfFile) readHeaders(r io.ReaderAt) error {
+	elfFile, err := elf.NewFile(r)
+	if err != nil {
+		return err
+	}
 	progs := make([]elf.ProgHeader, 0, len(elfFile.Progs))
 	sections := make([]elf.SectionHeader, 0, len(elfFile.Sections))
 	for i := range elfFile.Progs {
@@ -45,12 +81,14 @@ func NewMMapedElfFile(fpath string) (*MMapedElfFile, error) {
 	for i := range elfFile.Sections {
 		sections = append(sections, elfFile.Sections[i].SectionHeader)
 	}
-	res.FileHeader = elfFile.FileHeader
-	res.Progs = progs
-	res.Sections = sections
-
-	runtime.SetFinalizer(res, func(obj *MMapedElfFile) { obj.Finalize() })
-	return res, nil
+	f.FileHeader = elfFile.FileHeader
+	f.Progs = progs
+	f.Sections = sections
+	if len(sections) == 0 {
+		// section headers were stripped, fall back to the dynamic segment
+		f.Sections = f.dynamicSections(r)
+	}
+	return nil
 }
 
 func (f *MMapedElfFile) Section(name string) *elf.SectionHeader {
@@ -74,10 +112,25 @@ func (f *MMapedElfFile) sectionByType(typ elf.SectionType) *elf.SectionHeader {
 }
 
 func (f *MMapedElfFile) ensureOpen() error {
-	if f.fd != nil {
+	if f.opened.Load() {
 		return nil
 	}
-	return f.open()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fd == nil && f.data == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	f.opened.Store(true)
+	return nil
+}
+
+// failed reports whether the file could not be reopened.
+func (f *MMapedElfFile) failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err != nil
 }
 
 func (f *MMapedElfFile) Finalize() {
@@ -87,12 +140,27 @@ func (f *MMapedElfFile) Finalize() {
 	f.Close()
 }
 
+// Close releases the file descriptor, the file is reopened on the next
+// read. The contents of an in-memory file are kept.
 func (f *MMapedElfFile) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.data == nil || f.mapped {
+		f.opened.Store(false)
+	}
+	if f.mapped {
+		unix.Munmap(f.data)
+		f.data = nil
+		f.mapped = false
+	}
 	if f.fd != nil {
 		f.fd.Close()
 		f.fd = nil
 	}
-	f.stringCache = nil
+	f.stringCache.Range(func(k, _ any) bool {
+		f.stringCache.Delete(k)
+		return true
+	})
 	f.Sections = nil
 }
 
@@ -106,16 +174,68 @@ func (f *MMapedElfFile) open() error {
 		return fmt.Errorf("open elf file %s %w", f.fpath, err)
 	}
 	f.fd = fd
+	if mmapEnabled && canMmap(f.fpath) {
+		// fd.ReadAt is used if the file cannot be mapped
+		f.mmap()
+	}
 	return nil
 }
 
+// mmapEnabled switches between the mmap and the ReadAt backing, the latter
+// is kept for benchmarks.
+var mmapEnabled = true
+
+// canMmap reports whether fpath should be mapped. memfd and /proc/<pid>/fd
+// links are read with ReadAt: they may refer to files that are truncated or
+// rewritten while mapped, which would fault on access.
+func canMmap(fpath string) bool {
+	if strings.Contains(fpath, "memfd:") {
+		return false
+	}
+	rest, ok := strings.CutPrefix(fpath, "/proc/")
+	if !ok {
+		return true
+	}
+	_, rest, ok = strings.Cut(rest, "/")
+	return !ok || !strings.HasPrefix(rest, "fd/")
+}
+
+func (f *MMapedElfFile) mmap() {
+	info, err := f.fd.Stat()
+	if err != nil || info.Size() <= 0 || int64(int(info.Size())) != info.Size() {
+		return
+	}
+	data, err := unix.Mmap(int(f.fd.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_PRIVATE)
+	if err != nil {
+		return
+	}
+	// symbol names are looked up at random, readahead would only waste
+	// page cache
+	_ = unix.Madvise(data, unix.MADV_RANDOM)
+	f.data, f.mapped = data, true
+}
+
+// willNeed asks the kernel to read the range ahead of a sequential copy,
+// MADV_RANDOM disables readahead for the whole mapping.
+func (f *MMapedElfFile) willNeed(off, size uint64) {
+	if !f.mapped || off >= uint64(len(f.data)) {
+		return
+	}
+	page := uint64(os.Getpagesize())
+	start := off &^ (page - 1)
+	end := min(off+size, uint64(len(f.data)))
+	_ = unix.Madvise(f.data[start:end], unix.MADV_WILLNEED)
+}
+
 type SectionData struct {
 	Header elf.SectionHeader
 	Data   []byte
 }
 
+// GetSectionData returns the decompressed contents of the section name. A
+// .debug_* section is also looked up under its legacy .zdebug_* name.
 func (f *MMapedElfFile) GetSectionData(name string) (*SectionData, error) {
-	if scn := f.Section(name); scn != nil {
+	if scn := f.debugSection(name); scn != nil {
 		data, err := f.SectionData(scn)
 		if err != nil {
 			return nil, fmt.Errorf("get section data: %w", err)
@@ -125,50 +245,151 @@ func (f *MMapedElfFile) GetSectionData(name string) (*SectionData, error) {
 	return nil, fmt.Errorf("no section %s found", name)
 }
 
+// SectionData returns the contents of s, decompressed if s is a
+// SHF_COMPRESSED or .zdebug_* section.
 func (f *MMapedElfFile) SectionData(s *elf.SectionHeader) ([]byte, error) {
 	if err := f.ensureOpen(); err != nil {
 		return nil, err
 	}
-	res := make([]byte, s.Size)
-	if _, err := f.fd.ReadAt(res, int64(s.Offset)); err != nil {
+	compressed := s.Flags&elf.SHF_COMPRESSED != 0 || isZDebug(s)
+	size := s.Size
+	if compressed {
+		// Size is the decompressed size of SHF_COMPRESSED sections
+		size = s.FileSize
+	}
+	f.willNeed(s.Offset, size)
+	res := make([]byte, size)
+	if err := f.readAt(res, int64(s.Offset)); err != nil {
 		return nil, err
 	}
+	if compressed && s.Type != elf.SHT_NOBITS {
+		return f.decompressSection(s, res)
+	}
 	return res, nil
 }
 
+// sectionBytes returns the contents of the uncompressed section s, in place
+// when the file is mapped. The bytes must not be modified nor used after
+// Close.
+func (f *MMapedElfFile) sectionBytes(s *elf.SectionHeader) ([]byte, error) {
+	if err := f.ensureOpen(); err != nil {
+		return nil, err
+	}
+	if f.data == nil {
+		return f.SectionData(s)
+	}
+	if s.Offset+s.Size < s.Offset || s.Offset+s.Size > uint64(len(f.data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return f.data[s.Offset : s.Offset+s.Size], nil
+}
+
+// readAt reads len(data) bytes at off from the file or its in-memory
+// contents. The file must be open.
+func (f *MMapedElfFile) readAt(data []byte, off int64) error {
+	if f.data != nil {
+		if off < 0 || off+int64(len(data)) > int64(len(f.data)) {
+			return io.ErrUnexpectedEOF
+		}
+		copy(data, f.data[off:])
+		return nil
+	}
+	_, err := f.fd.ReadAt(data, off)
+	return err
+}
+
 func (f *MMapedElfFile) FilePath() string { return f.fpath }
 
+// addrOffset translates a virtual address to a file offset using the
+// PT_LOAD segments.
+func (f *MMapedElfFile) addrOffset(addr uint64) (uint64, bool) {
+	for i := range f.Progs {
+		p := &f.Progs[i]
+		if p.Type == elf.PT_LOAD && addr >= p.Vaddr && addr < p.Vaddr+p.Filesz {
+			return p.Off + addr - p.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// vaddrReader reads the file contents at virtual addresses.
+type vaddrReader struct {
+	f *MMapedElfFile
+}
+
+func (r *vaddrReader) ReadAt(data []byte, addr int) error {
+	off, ok := r.f.addrOffset(uint64(addr))
+	if !ok {
+		return fmt.Errorf("address 0x%x not mapped", addr)
+	}
+	if err := r.f.ensureOpen(); err != nil {
+		return err
+	}
+	return r.f.readAt(data, int64(off))
+}
+
+// offsetReader reads the file contents at offsets relative to off.
+type offsetReader struct {
+	f   *MMapedElfFile
+	off int
+}
+
+func (r *offsetReader) ReadAt(data []byte, offset int) error {
+	if err := r.f.ensureOpen(); err != nil {
+		return err
+	}
+	return r.f.readAt(data, int64(r.off+offset))
+}
+
 // getString extracts a string from an ELF string table.
-func (f *MMapedElfFile) getString(start int, demangleOptions []demangle.Option) (string, bool) {
+func (f *MMapedElfFile) getString(start int, demangleOptions []demangle.Option, keepRustHash bool) (string, bool) {
 	if err := f.ensureOpen(); err != nil {
 		return "", false
 	}
-	if s, ok := f.stringCache[start]; ok {
-		return s, true
+	if s, ok := f.stringCache.Load(start); ok {
+		return s.(string), true
 	}
+	s, ok := f.readString(start)
+	if !ok {
+		return "", false
+	}
+	if len(demangleOptions) > 0 {
+		s = demangleName(s, demangleOptions, keepRustHash)
+	}
+	f.stringCache.Store(start, s)
+	return s, true
+}
+
+// maxStringSize bounds the length of symbol names
+const maxStringSize = 10 * 128
+
+// readString reads the NUL terminated string at start.
+func (f *MMapedElfFile) readString(start int) (string, bool) {
+	if f.data != nil {
+		if start < 0 || start >= len(f.data) {
+			return "", false
+		}
+		b := f.data[start:min(start+maxStringSize, len(f.data))]
+		idx := bytes.IndexByte(b, 0)
+		if idx < 0 {
+			return "", false
+		}
+		return string(b[:idx]), true
+	}
+
 	const tmpBufSize = 128
 	var tmpBuf [tmpBufSize]byte
 	sb := strings.Builder{}
-	for i := 0; i < 10; i++ {
-		_, err := f.fd.ReadAt(tmpBuf[:], int64(start+i*tmpBufSize))
+	for i := 0; i < maxStringSize/tmpBufSize; i++ {
+		n, err := f.fd.ReadAt(tmpBuf[:], int64(start+i*tmpBufSize))
+		if idx := bytes.IndexByte(tmpBuf[:n], 0); idx >= 0 {
+			sb.Write(tmpBuf[:idx])
+			return sb.String(), true
+		}
 		if err != nil {
 			return "", false
 		}
-		idx := bytes.IndexByte(tmpBuf[:], 0)
-		if idx >= 0 {
-			sb.Write(tmpBuf[:idx])
-			s := sb.String()
-			if len(demangleOptions) > 0 {
-				s = demangle.Filter(s, demangleOptions...)
-			}
-			if f.stringCache == nil {
-				f.stringCache = make(map[int]string)
-			}
-			f.stringCache[start] = s
-			return s, true
-		} else {
-			sb.Write(tmpBuf[:])
-		}
+		sb.Write(tmpBuf[:])
 	}
 	return "", false
 }
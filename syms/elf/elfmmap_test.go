@@ -2,9 +2,14 @@ package elf
 
 import (
 	"debug/elf"
+	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/ianlancetaylor/demangle"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slices"
 )
@@ -27,15 +32,18 @@ func TestElfSymbolComparison(t *testing.T) {
 		}
 		var mySymbols []TestSym
 
-		require.Equal(t, len(genuineSymbols), len(tab.Index.Names))
-		for i, symbol := range genuineSymbols {
-			require.Equal(t, symbol.Start, tab.Index.Values.Value(i))
+		for i := range tab.Index.Names {
+			// name@plt symbols are synthesized
+			if tab.Index.Names[i].LinkIndex() == sectionTypePlt {
+				continue
+			}
 			name, _ := tab.symbolName(i)
 			mySymbols = append(mySymbols, TestSym{
 				Name:  name,
-				Start: symbol.Start,
+				Start: tab.Index.Values.Value(i),
 			})
 		}
+		require.Equal(t, len(genuineSymbols), len(mySymbols))
 
 		cmp := func(a, b TestSym) int {
 			if a.Start == b.Start {
@@ -53,6 +61,8 @@ func TestElfSymbolComparison(t *testing.T) {
 		"./testdata/elfs/elf.debug",
 		"./testdata/elfs/elf.nopie",
 		"./testdata/elfs/libexample.so",
+		"./testdata/elfs/elf.386",
+		"./testdata/elfs/libexample32.so.1",
 		"./testdata/elfs/go12",
 		"./testdata/elfs/go16",
 		"./testdata/elfs/go18",
@@ -61,6 +71,8 @@ func TestElfSymbolComparison(t *testing.T) {
 		"./testdata/elfs/go16-static",
 		"./testdata/elfs/go18-static",
 		"./testdata/elfs/go20-static",
+		"./testdata/elfs/go21-386",
+		"./testdata/elfs/go21-s390x",
 	}
 	for _, f := range fs {
 		t.Run(f, func(t *testing.T) {
@@ -68,3 +80,138 @@ func TestElfSymbolComparison(t *testing.T) {
 		})
 	}
 }
+
+func TestCanMmap(t *testing.T) {
+	testcases := map[string]bool{
+		"/usr/lib/libc.so.6":           true,
+		"/proc/123/root/usr/bin/app":   true,
+		"/proc/self/fd/12":             false,
+		"/proc/123/fd/3":               false,
+		"/memfd:jit (deleted)":         false,
+		"/proc/123/map_files/1000-200": true,
+	}
+	for path, expected := range testcases {
+		assert.Equal(t, expected, canMmap(path), path)
+	}
+}
+
+func TestMMapedElfFile_ReadAt(t *testing.T) {
+	// both backings read the same symbols
+	resolveAll := func(t *testing.T) []string {
+		me, err := NewMMapedElfFile("./testdata/elfs/go20")
+		require.NoError(t, err)
+		defer me.Close()
+		tab, err := me.NewSymbolTable(new(SymbolOptions))
+		require.NoError(t, err)
+		var res []string
+		for i := range tab.Index.Names {
+			name, err := tab.symbolName(i)
+			require.NoError(t, err)
+			res = append(res, name)
+		}
+		return res
+	}
+	mapped := resolveAll(t)
+	setMmap(t, false)
+	assert.Equal(t, mapped, resolveAll(t))
+}
+
+func TestMMapedElfFile_ConcurrentReads(t *testing.T) {
+	for _, mmap := range []bool{true, false} {
+		t.Run(fmt.Sprintf("mmap=%t", mmap), func(t *testing.T) {
+			setMmap(t, mmap)
+			me, err := NewMMapedElfFile("./testdata/elfs/go20")
+			require.NoError(t, err)
+			symtbl, err := me.NewSymbolTable(&SymbolOptions{DemangleOpts: []demangle.Option{demangle.NoClones}})
+			require.NoError(t, err)
+			gotbl, err := me.NewGoTable(symtbl)
+			require.NoError(t, err)
+			defer gotbl.Cleanup()
+
+			var addrs []uint64
+			for i := 0; i < gotbl.Index.Entry.Length(); i += 10 {
+				addrs = append(addrs, gotbl.Index.Entry.Get(i)+1)
+			}
+			for i := 0; i < symtbl.Index.Values.Length(); i += 10 {
+				addrs = append(addrs, symtbl.Index.Values.Get(i))
+			}
+			names := make([]string, len(addrs))
+			frames := make([][]Frame, len(addrs))
+			for i, addr := range addrs {
+				names[i], frames[i] = gotbl.Resolve(addr), gotbl.ResolveFrames(addr)
+			}
+			require.NotEmpty(t, names[0])
+			require.NotEmpty(t, frames[0])
+
+			// the file is reopened by the first reader
+			me.Close()
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i, addr := range addrs {
+						assert.Equal(t, names[i], gotbl.Resolve(addr))
+						assert.Equal(t, frames[i], gotbl.ResolveFrames(addr))
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func setMmap(tb testing.TB, enabled bool) {
+	old := mmapEnabled
+	mmapEnabled = enabled
+	tb.Cleanup(func() { mmapEnabled = old })
+}
+
+var benchElfs = []string{
+	"./testdata/elfs/elf",
+	"./testdata/elfs/elf.dwarf.debug",
+	"./testdata/elfs/go20",
+	"./testdata/elfs/go20-static",
+}
+
+// BenchmarkSymbolTable loads the symbol table of each binary and resolves
+// every symbol once, as done for a new process.
+func BenchmarkSymbolTable(b *testing.B) {
+	for _, mode := range []string{"mmap", "readat"} {
+		for _, f := range benchElfs {
+			b.Run(mode+"/"+filepath.Base(f), func(b *testing.B) {
+				setMmap(b, mode == "mmap")
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					me, err := NewMMapedElfFile(f)
+					require.NoError(b, err)
+					tab, err := me.NewSymbolTable(new(SymbolOptions))
+					require.NoError(b, err)
+					for j := 0; j < tab.Index.Values.Length(); j++ {
+						tab.Resolve(tab.Index.Values.Get(j))
+					}
+					me.Close()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSectionData reads the DWARF sections of a debug file.
+func BenchmarkSectionData(b *testing.B) {
+	for _, mode := range []string{"mmap", "readat"} {
+		b.Run(mode, func(b *testing.B) {
+			setMmap(b, mode == "mmap")
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				me, err := NewMMapedElfFile("./testdata/elfs/elf.dwarf.debug")
+				require.NoError(b, err)
+				for _, name := range []string{".debug_info", ".debug_line", ".debug_str", ".symtab", ".strtab"} {
+					_, err = me.GetSectionData(name)
+					require.NoError(b, err)
+				}
+				me.Close()
+			}
+		})
+	}
+}
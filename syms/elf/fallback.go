@@ -6,8 +6,17 @@ type Table interface {
 	Cleanup()
 }
 
+// StartTable is implemented by tables which know where their symbols
+// start.
+type StartTable interface {
+	// ResolveStart returns the name of the symbol at addr and the address
+	// where the symbol starts.
+	ResolveStart(addr uint64) (string, uint64)
+}
+
 type emptyFallback struct{}
 
-func (*emptyFallback) Resolve(uint64) string { return "" }
-func (*emptyFallback) Size() int             { return 0 }
-func (*emptyFallback) Cleanup()              {}
+func (*emptyFallback) Resolve(uint64) string                { return "" }
+func (*emptyFallback) ResolveStart(uint64) (string, uint64) { return "", 0 }
+func (*emptyFallback) Size() int                            { return 0 }
+func (*emptyFallback) Cleanup()                             {}
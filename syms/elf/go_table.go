@@ -1,6 +1,7 @@
 package elf
 
 import (
+	"bytes"
 	"debug/elf"
 	"errors"
 	"fmt"
@@ -13,11 +14,15 @@ type GoTable struct {
 	File           *MMapedElfFile
 	gopclnSection  elf.SectionHeader
 	funcNameOffset uint64
+	textStart      uint64
 	fallback       Table
+	// lines is built with the section headers, which are dropped when the
+	// file is closed. It is nil if the pclntab cannot be parsed.
+	lines *gosym2.LineTable
 }
 
 func (g *GoTable) IsDead() bool {
-	return g.File.err != nil
+	return g.File.failed()
 }
 
 func (g *GoTable) DebugInfo() SymTabDebugInfo {
@@ -41,15 +46,20 @@ func (g *GoTable) Resolve(addr uint64) string {
 	return g.fallback.Resolve(addr)
 }
 
-func (g *GoTable) resolve(addr uint64) string {
-	n := len(g.Index.Name)
-	if n == 0 {
-		return ""
+func (g *GoTable) ResolveStart(addr uint64) (string, uint64) {
+	if i := g.findIndex(addr); i != -1 {
+		if name, _ := g.goSymbolName(i); name != "" {
+			return name, g.Index.Entry.Get(i)
+		}
 	}
-	if addr >= g.Index.End {
-		return ""
+	if t, ok := g.fallback.(StartTable); ok {
+		return t.ResolveStart(addr)
 	}
-	i := g.Index.Entry.FindIndex(addr)
+	return g.fallback.Resolve(addr), 0
+}
+
+func (g *GoTable) resolve(addr uint64) string {
+	i := g.findIndex(addr)
 	if i == -1 {
 		return ""
 	}
@@ -57,11 +67,106 @@ func (g *GoTable) resolve(addr uint64) string {
 	return name
 }
 
+func (g *GoTable) findIndex(addr uint64) int {
+	if len(g.Index.Name) == 0 || addr >= g.Index.End {
+		return -1
+	}
+	return g.Index.Entry.FindIndex(addr)
+}
+
+// ResolveFrames returns the logical frames of addr, innermost first, with
+// the source positions from the pcfile/pcln tables and the frames of
+// inlined calls from the inline tree. It returns nil for addresses outside
+// of Go functions.
+func (g *GoTable) ResolveFrames(addr uint64) []Frame {
+	i := g.findIndex(addr)
+	if i == -1 || g.lines == nil {
+		return nil
+	}
+	frames := g.lines.PCToFrames(i, addr)
+	if len(frames) == 0 {
+		return nil
+	}
+	res := make([]Frame, len(frames))
+	for j, f := range frames {
+		res[j] = Frame{Name: f.Name, File: f.File, Line: f.Line}
+	}
+	return res
+}
+
+// ResolveLine returns the source file and line of addr.
+func (g *GoTable) ResolveLine(addr uint64) (string, int) {
+	if frames := g.ResolveFrames(addr); len(frames) > 0 {
+		return frames[0].File, frames[0].Line
+	}
+	return "", 0
+}
+
+// newLineTable returns the line table of pclntab. It reads through f, which
+// reopens the file once closed, and is safe for concurrent use.
+func (f *MMapedElfFile) newLineTable(pclntab *elf.SectionHeader, textStart uint64) *gosym2.LineTable {
+	pcln := gosym2.NewLineTableStreaming(&offsetReader{f: f, off: int(pclntab.Offset)}, textStart)
+	if !pcln.IsGo12() || pcln.IsFailed() {
+		return nil
+	}
+	pcln.FuncData = &vaddrReader{f: f}
+	pcln.GoFunc = f.goFuncAddr(pclntab, textStart)
+	return pcln
+}
+
 func (g *GoTable) Cleanup() {
 	g.File.Close()
 	g.fallback.Cleanup()
 }
 
+// sections holding the pclntab when there is no .gopclntab, e.g. after
+// external linking or stripping
+var pclntabSections = []string{".data.rel.ro", ".rodata"}
+
+// findPclntab looks for a Go 1.16+ pcHeader in the sections that may embed
+// the pclntab, it returns a synthesized .gopclntab header starting there.
+// Only Go binaries are searched, the sections of large C++ libraries take
+// long to scan.
+func (f *MMapedElfFile) findPclntab() *elf.SectionHeader {
+	if !f.isGo() {
+		return nil
+	}
+	for _, name := range pclntabSections {
+		s := f.Section(name)
+		if s == nil || s.Type == elf.SHT_NOBITS || s.Flags&elf.SHF_COMPRESSED != 0 {
+			continue
+		}
+		data, err := f.sectionBytes(s)
+		if err != nil {
+			continue
+		}
+		off := gosym2.FindPclntab(data)
+		if off < 0 {
+			continue
+		}
+		return &elf.SectionHeader{
+			Name:     ".gopclntab",
+			Type:     elf.SHT_PROGBITS,
+			Flags:    s.Flags,
+			Addr:     s.Addr + uint64(off),
+			Offset:   s.Offset + uint64(off),
+			Size:     s.Size - uint64(off),
+			FileSize: s.Size - uint64(off),
+		}
+	}
+	return nil
+}
+
+// isGo reports whether the file has a Go build ID or Go build info. The
+// notes are only scanned in files without section headers, the Go linker
+// always emits the sections.
+func (f *MMapedElfFile) isGo() bool {
+	if len(f.Sections) > 0 {
+		return f.Section(".note.go.buildid") != nil || f.Section(".go.buildinfo") != nil
+	}
+	return f.findNote("Go", noteGoBuildID) != nil
+}
+
 var (
 	errEmptyText         = errors.New("empty .text")
 	errGoPCLNTabNotFound = errors.New(".gopclntab not found")
@@ -81,7 +186,9 @@ func (f *MMapedElfFile) NewGoTable(fallback Table) (*GoTable, error) {
 	}
 	pclntab := obj.Section(".gopclntab")
 	if pclntab == nil {
-		return nil, errGoPCLNTabNotFound
+		if pclntab = obj.findPclntab(); pclntab == nil {
+			return nil, errGoPCLNTabNotFound
+		}
 	}
 	if f.fd == nil {
 		return nil, fmt.Errorf("elf file not open")
@@ -136,7 +243,9 @@ func (f *MMapedElfFile) NewGoTable(fallback Table) (*GoTable, error) {
 		File:           f,
 		gopclnSection:  *pclntab,
 		funcNameOffset: funcNameOffset,
+		textStart:      textStart,
 		fallback:       fallback,
+		lines:          f.newLineTable(pclntab, textStart),
 	}, nil
 }
 
@@ -146,6 +255,12 @@ func (g *GoTable) SetFallback(fallback Table) {
 	}
 }
 
+// Fallback returns the table resolving the addresses outside of Go
+// functions.
+func (g *GoTable) Fallback() Table {
+	return g.fallback
+}
+
 func (g *GoTable) goSymbolName(idx int) (string, error) {
 	offsetGpcln := g.gopclnSection.Offset
 	if idx >= len(g.Index.Name) {
@@ -153,9 +268,132 @@ func (g *GoTable) goSymbolName(idx int) (string, error) {
 	}
 
 	offsetName := g.Index.Name[idx]
-	name, ok := g.File.getString(int(offsetGpcln)+int(g.funcNameOffset)+int(offsetName), nil)
+	name, ok := g.File.getString(int(offsetGpcln)+int(g.funcNameOffset)+int(offsetName), nil, false)
 	if !ok {
 		return "", errGoFailed
 	}
 	return name, nil
 }
+
+// pcHeader magics of Go 1.18 and Go 1.20+
+const (
+	goMagic118 = 0xfffffff0
+	goMagic120 = 0xfffffff1
+)
+
+// word indexes of fields of runtime.moduledata, covctrs and ecovctrs were
+// added before end in Go 1.20
+const (
+	moduledataText     = 22
+	moduledataGoFunc   = 40
+	moduledataGoFunc18 = 38
+)
+
+// sections holding runtime.firstmoduledata
+var moduledataSections = []string{".noptrdata", ".data"}
+
+// goFuncAddr returns the address of the go:func.* symbol (go.func.* before
+// Go 1.20), the base of funcdata offsets since Go 1.18. It is read from
+// runtime.firstmoduledata like the runtime does, as stripped binaries have
+// no symbol table, and from the symbol table if the moduledata is not found.
+// It returns 0 if neither is available.
+func (f *MMapedElfFile) goFuncAddr(pclntab *elf.SectionHeader, textStart uint64) uint64 {
+	if addr := f.moduledataGoFunc(pclntab, textStart); addr != 0 {
+		return addr
+	}
+	return f.goFuncSymbol()
+}
+
+// moduledataGoFunc finds runtime.firstmoduledata by its first field, the
+// address of the pcHeader, and returns its gofunc field. The text field must
+// match the pcHeader for the layout to be trusted.
+func (f *MMapedElfFile) moduledataGoFunc(pclntab *elf.SectionHeader, textStart uint64) uint64 {
+	var hdr [8]byte
+	if err := f.readAt(hdr[:], int64(pclntab.Offset)); err != nil {
+		return 0
+	}
+	var goFuncIdx int
+	switch f.ByteOrder.Uint32(hdr[:]) {
+	case goMagic120:
+		goFuncIdx = moduledataGoFunc
+	case goMagic118:
+		goFuncIdx = moduledataGoFunc18
+	default:
+		// funcdata are addressed directly before Go 1.18
+		return 0
+	}
+	ptrSize := int(hdr[7])
+	if ptrSize != 4 && ptrSize != 8 {
+		return 0
+	}
+	word := func(data []byte, i int) uint64 {
+		if ptrSize == 4 {
+			return uint64(f.ByteOrder.Uint32(data[i*ptrSize:]))
+		}
+		return f.ByteOrder.Uint64(data[i*ptrSize:])
+	}
+	size := (goFuncIdx + 1) * ptrSize
+	for _, name := range moduledataSections {
+		s := f.Section(name)
+		if s == nil || s.Type == elf.SHT_NOBITS || s.Flags&elf.SHF_COMPRESSED != 0 {
+			continue
+		}
+		data, err := f.sectionBytes(s)
+		if err != nil {
+			continue
+		}
+		for off := 0; off+size <= len(data); off += ptrSize {
+			md := data[off:]
+			if word(md, 0) != pclntab.Addr || word(md, moduledataText) != textStart {
+				continue
+			}
+			if addr := word(md, goFuncIdx); addr != 0 {
+				if _, ok := f.addrOffset(addr); ok {
+					return addr
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// goFuncSymbol returns the address of the go:func.* symbol from the symbol
+// table, 0 if there is none.
+func (f *MMapedElfFile) goFuncSymbol() uint64 {
+	symtab := f.sectionByType(elf.SHT_SYMTAB)
+	if symtab == nil || int(symtab.Link) >= len(f.Sections) {
+		return 0
+	}
+	strtab, err := f.SectionData(&f.Sections[symtab.Link])
+	if err != nil {
+		return 0
+	}
+	nameIdx := -1
+	for _, name := range []string{"go:func.*", "go.func.*"} {
+		if i := bytes.Index(strtab, []byte("\x00"+name+"\x00")); i >= 0 {
+			nameIdx = i + 1
+			break
+		}
+	}
+	if nameIdx < 0 {
+		return 0
+	}
+	data, err := f.SectionData(symtab)
+	if err != nil {
+		return 0
+	}
+	symSize, valueAt := elf.Sym64Size, 8
+	if f.Class == elf.ELFCLASS32 {
+		symSize, valueAt = elf.Sym32Size, 4
+	}
+	for ; len(data) >= symSize; data = data[symSize:] {
+		if f.ByteOrder.Uint32(data) != uint32(nameIdx) {
+			continue
+		}
+		if f.Class == elf.ELFCLASS32 {
+			return uint64(f.ByteOrder.Uint32(data[valueAt:]))
+		}
+		return f.ByteOrder.Uint64(data[valueAt:])
+	}
+	return 0
+}
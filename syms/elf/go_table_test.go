@@ -2,8 +2,10 @@ package elf
 
 import (
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,6 +14,9 @@ func TestSelfGoSymbolComparison(t *testing.T) {
 		for _, symbol := range expectedSymbols {
 			name := goTable.Resolve(symbol.Start)
 			require.Equal(t, symbol.Name, name)
+			name, start := goTable.ResolveStart(symbol.Start + 1)
+			require.Equal(t, symbol.Name, name)
+			require.Equal(t, symbol.Start, start)
 		}
 
 		first := goTable.Index.Entry.First()
@@ -35,6 +40,8 @@ func TestSelfGoSymbolComparison(t *testing.T) {
 		{"./testdata/elfs/go16-static", false}, // this one switches from 32 to 64 in the middle
 		{"./testdata/elfs/go18-static", false}, // this one starts with 64
 		{"./testdata/elfs/go20-static", true},
+		{"./testdata/elfs/go21-386", true},
+		{"./testdata/elfs/go21-s390x", true},
 	}
 	for _, testcase := range ts {
 		t.Run(testcase.f, func(t *testing.T) {
@@ -67,3 +74,231 @@ func TestSelfGoSymbolComparison(t *testing.T) {
 		})
 	}
 }
+
+func TestGoTable_ResolveFrames(t *testing.T) {
+	fs := []string{
+		"./testdata/elfs/go12",
+		"./testdata/elfs/go16",
+		"./testdata/elfs/go18",
+		"./testdata/elfs/go20",
+		"./testdata/elfs/go12-static",
+		"./testdata/elfs/go16-static",
+		"./testdata/elfs/go18-static",
+		"./testdata/elfs/go20-static",
+	}
+	for _, f := range fs {
+		t.Run(f, func(t *testing.T) {
+			expected, err := GetGoTable(f, strings.Contains(f, "go20"))
+			require.NoError(t, err)
+
+			me, err := NewMMapedElfFile(f)
+			require.NoError(t, err)
+			defer me.Close()
+
+			goTable, err := me.NewGoTable(nil)
+			require.NoError(t, err)
+
+			for i, fn := range expected.Funcs {
+				if i%7 != 0 {
+					continue
+				}
+				for pc := fn.Entry; pc < fn.End; pc += 5 {
+					file, line, _ := expected.PCToLine(pc)
+					frames := goTable.ResolveFrames(pc)
+					require.NotEmpty(t, frames, "pc 0x%x", pc)
+					require.Equal(t, file, frames[0].File, "pc 0x%x", pc)
+					require.Equal(t, line, frames[0].Line, "pc 0x%x", pc)
+					require.Equal(t, fn.Name, frames[len(frames)-1].Name, "pc 0x%x", pc)
+				}
+			}
+			require.Nil(t, goTable.ResolveFrames(goTable.Index.End))
+		})
+	}
+}
+
+func TestGoTable_InlinedFrames(t *testing.T) {
+	testcases := []struct {
+		f      string
+		frames []Frame
+	}{
+		{"./testdata/elfs/go16", []Frame{
+			{"internal/cpu.indexByte", "/usr/local/go/src/internal/cpu/cpu.go", 220},
+			{"internal/cpu.processOptions", "/usr/local/go/src/internal/cpu/cpu.go", 152},
+		}},
+		{"./testdata/elfs/go18", []Frame{
+			{"internal/cpu.indexByte", "/usr/local/go/src/internal/cpu/cpu.go", 214},
+			{"internal/cpu.processOptions", "/usr/local/go/src/internal/cpu/cpu.go", 151},
+		}},
+		{"./testdata/elfs/go20", []Frame{
+			{"internal/cpu.indexByte", "/usr/local/go/src/internal/cpu/cpu.go", 217},
+			{"internal/cpu.processOptions", "/usr/local/go/src/internal/cpu/cpu.go", 154},
+		}},
+		// no symbol table, the funcdata base is read from the moduledata
+		{"./testdata/elfs/go20.stripped", []Frame{
+			{"internal/cpu.indexByte", "/usr/local/go/src/internal/cpu/cpu.go", 217},
+			{"internal/cpu.processOptions", "/usr/local/go/src/internal/cpu/cpu.go", 154},
+		}},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.f, func(t *testing.T) {
+			me, err := NewMMapedElfFile(tt.f)
+			require.NoError(t, err)
+			defer me.Close()
+
+			goTable, err := me.NewGoTable(nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.frames, goTable.ResolveFrames(0x4010a2))
+
+			file, line := goTable.ResolveLine(0x4010a2)
+			require.Equal(t, tt.frames[0].File, file)
+			require.Equal(t, tt.frames[0].Line, line)
+		})
+	}
+}
+
+func TestGoTable_GoFuncAddr(t *testing.T) {
+	for _, f := range []string{
+		"./testdata/elfs/go18",
+		"./testdata/elfs/go18-static",
+		"./testdata/elfs/go20",
+		"./testdata/elfs/go20-static",
+		"./testdata/elfs/go21-386",
+		"./testdata/elfs/go21-s390x",
+	} {
+		t.Run(f, func(t *testing.T) {
+			me, err := NewMMapedElfFile(f)
+			require.NoError(t, err)
+			defer me.Close()
+			goTable, err := me.NewGoTable(nil)
+			require.NoError(t, err)
+
+			expected := me.goFuncSymbol()
+			require.NotZero(t, expected)
+			assert.Equal(t, expected, me.moduledataGoFunc(me.Section(".gopclntab"), goTable.textStart))
+		})
+	}
+
+	me, err := NewMMapedElfFile("./testdata/elfs/go20.stripped")
+	require.NoError(t, err)
+	defer me.Close()
+	goTable, err := me.NewGoTable(nil)
+	require.NoError(t, err)
+	assert.Zero(t, me.goFuncSymbol())
+	assert.Equal(t, uint64(0x4a1be8), goTable.lines.GoFunc)
+}
+
+func TestGoTable_Concurrent(t *testing.T) {
+	me, err := NewMMapedElfFile("./testdata/elfs/go20")
+	require.NoError(t, err)
+	goTable, err := me.NewGoTable(nil)
+	require.NoError(t, err)
+	defer goTable.Cleanup()
+
+	pcs := make([]uint64, 0, 256)
+	frames := make([][]Frame, 0, 256)
+	for i := 0; i < goTable.Index.Entry.Length() && len(pcs) < cap(pcs); i += 3 {
+		pc := goTable.Index.Entry.Get(i)
+		pcs = append(pcs, pc)
+		frames = append(frames, goTable.ResolveFrames(pc))
+	}
+	// the line table reads the file reopened by the first reader
+	me.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, pc := range pcs {
+				assert.Equal(t, frames[i], goTable.ResolveFrames(pc), "pc 0x%x", pc)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkGoTable_ResolveFrames resolves the frames of every Go function
+// from concurrent goroutines.
+func BenchmarkGoTable_ResolveFrames(b *testing.B) {
+	me, err := NewMMapedElfFile("./testdata/elfs/go20")
+	require.NoError(b, err)
+	goTable, err := me.NewGoTable(nil)
+	require.NoError(b, err)
+	defer goTable.Cleanup()
+	n := goTable.Index.Entry.Length()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			goTable.ResolveFrames(goTable.Index.Entry.Get(i % n))
+		}
+	})
+}
+
+func TestGoTable_FindPclntab(t *testing.T) {
+	fs := []string{
+		"./testdata/elfs/go16",
+		"./testdata/elfs/go18",
+		"./testdata/elfs/go20",
+		"./testdata/elfs/go16-static",
+		"./testdata/elfs/go18-static",
+		"./testdata/elfs/go20-static",
+	}
+	for _, f := range fs {
+		t.Run(f, func(t *testing.T) {
+			me, err := NewMMapedElfFile(f)
+			require.NoError(t, err)
+			defer me.Close()
+			expected, err := me.NewGoTable(nil)
+			require.NoError(t, err)
+
+			// merge .gopclntab into .rodata, as if the section header was lost
+			rodata, pclntab := me.Section(".rodata"), me.Section(".gopclntab")
+			require.NotNil(t, rodata)
+			require.NotNil(t, pclntab)
+			rodata.Size = pclntab.Offset + pclntab.Size - rodata.Offset
+			pclntab.Name = ""
+
+			goTable, err := me.NewGoTable(nil)
+			require.NoError(t, err)
+			require.Equal(t, expected.gopclnSection.Offset, goTable.gopclnSection.Offset)
+			require.Equal(t, expected.Index.Name, goTable.Index.Name)
+			for i := 0; i < expected.Index.Entry.Length(); i += 11 {
+				pc := expected.Index.Entry.Get(i)
+				require.Equal(t, expected.Resolve(pc), goTable.Resolve(pc))
+			}
+		})
+	}
+
+	me, err := NewMMapedElfFile("./testdata/elfs/go12")
+	require.NoError(t, err)
+	defer me.Close()
+	me.Section(".gopclntab").Name = ""
+	_, err = me.NewGoTable(nil)
+	require.ErrorIs(t, err, errGoPCLNTabNotFound)
+
+	// files which are not Go binaries are not searched
+	for _, f := range []string{"./testdata/elfs/elf", "./testdata/elfs/elf.nosections", "./testdata/elfs/rust.v0"} {
+		me, err := NewMMapedElfFile(f)
+		require.NoError(t, err)
+		defer me.Close()
+		require.False(t, me.isGo(), f)
+	}
+	for _, f := range []string{"./testdata/elfs/go16", "./testdata/elfs/go20-static", "./testdata/elfs/go21-386"} {
+		me, err := NewMMapedElfFile(f)
+		require.NoError(t, err)
+		defer me.Close()
+		require.True(t, me.isGo(), f)
+	}
+
+	// the notes are only scanned without section headers
+	me, err = NewMMapedElfFile("./testdata/elfs/go20")
+	require.NoError(t, err)
+	defer me.Close()
+	sections := me.Sections
+	me.Section(".note.go.buildid").Name = ""
+	me.Section(".go.buildinfo").Name = ""
+	require.False(t, me.isGo())
+	me.Sections = nil
+	require.True(t, me.isGo())
+	me.Sections = sections
+}
@@ -0,0 +1,211 @@
+package elf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"github.com/vietanhduong/profiling/syms/gosym"
+)
+
+// MaterializedTable is a symbol table holding its names in memory, built
+// from the tables of a file with Materialize. It does not need the file to
+// resolve and can be serialized, see MarshalBinary.
+type MaterializedTable struct {
+	Values gosym.PCIndex
+	// size of each symbol, 0 if unknown
+	Sizes gosym.PCIndex
+	Names []string
+	// GoFuncs is set when the table contains the functions of a Go pclntab
+	GoFuncs bool
+}
+
+// Materialize reads the names of every symbol of a *SymbolTable or of a
+// *GoTable and its *SymbolTable fallback.
+func Materialize(t Table) (*MaterializedTable, error) {
+	var syms []materializedSym
+	var goFuncs bool
+	switch t := t.(type) {
+	case *SymbolTable:
+		syms = t.materialize(syms)
+	case *GoTable:
+		syms = t.materialize(syms)
+		goFuncs = true
+		if st, ok := t.fallback.(*SymbolTable); ok {
+			syms = st.materialize(syms)
+		}
+	default:
+		return nil, fmt.Errorf("materialize: unsupported table %T", t)
+	}
+	// The symbols of the fallback do not overlap with the Go functions,
+	// symbols starting at the same address keep their order.
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].value < syms[j].value })
+
+	res := &MaterializedTable{
+		Values:  gosym.NewPCIndex(len(syms)),
+		Sizes:   gosym.NewPCIndex(len(syms)),
+		Names:   make([]string, len(syms)),
+		GoFuncs: goFuncs,
+	}
+	for i, s := range syms {
+		res.Values.Set(i, s.value)
+		res.Sizes.Set(i, s.size)
+		res.Names[i] = s.name
+	}
+	return res, nil
+}
+
+type materializedSym struct {
+	value, size uint64
+	name        string
+}
+
+func (st *SymbolTable) materialize(res []materializedSym) []materializedSym {
+	for i := range st.Index.Names {
+		name, err := st.symbolName(i)
+		if err != nil {
+			continue
+		}
+		res = append(res, materializedSym{value: st.Index.Values.Get(i), size: st.Index.Sizes.Get(i), name: name})
+	}
+	return res
+}
+
+func (g *GoTable) materialize(res []materializedSym) []materializedSym {
+	n := g.Index.Entry.Length()
+	for i := 0; i < n; i++ {
+		name, err := g.goSymbolName(i)
+		if err != nil {
+			continue
+		}
+		// a Go function extends up to the next one
+		end := g.Index.End
+		if i+1 < n {
+			end = g.Index.Entry.Get(i + 1)
+		}
+		start := g.Index.Entry.Get(i)
+		res = append(res, materializedSym{value: start, size: end - start, name: name})
+	}
+	return res
+}
+
+func (t *MaterializedTable) Resolve(addr uint64) string {
+	name, _ := t.ResolveStart(addr)
+	return name
+}
+
+func (t *MaterializedTable) ResolveStart(addr uint64) (string, uint64) {
+	if len(t.Names) == 0 {
+		return "", 0
+	}
+	i := t.Values.FindIndex(addr)
+	if i == -1 {
+		return "", 0
+	}
+	// same rules as SymbolTable.Resolve
+	start := t.Values.Get(i)
+	for ; i < len(t.Names) && t.Values.Get(i) == start; i++ {
+		if size := t.Sizes.Get(i); size == 0 || addr < start+size {
+			return t.Names[i], start
+		}
+	}
+	return "", 0
+}
+
+func (t *MaterializedTable) Size() int    { return len(t.Names) }
+func (t *MaterializedTable) IsDead() bool { return false }
+func (t *MaterializedTable) Cleanup()     {}
+
+// The serialized table is:
+//
+//	magic[8] version:u32 flags:u32 count:u32 namesSize:u32
+//	count * (value:u64 size:u64 nameOffset:u32 nameSize:u32)
+//	names[namesSize]
+//	crc32:u32
+//
+// in little endian, the checksum covers everything before it.
+
+var materializedMagic = []byte("SYMTABv1")
+
+const (
+	materializedVersion    = 1
+	materializedHeaderSize = 24
+	materializedEntrySize  = 24
+
+	materializedGoFuncs = 1 << 0
+)
+
+var ErrInvalidMaterializedTable = errors.New("invalid materialized table")
+
+func (t *MaterializedTable) MarshalBinary() ([]byte, error) {
+	namesSize := 0
+	for _, name := range t.Names {
+		namesSize += len(name)
+	}
+	n := len(t.Names)
+	if uint64(namesSize) > 1<<32-1 || uint64(n) > 1<<32-1 {
+		return nil, fmt.Errorf("materialized table too large")
+	}
+	res := make([]byte, materializedHeaderSize, materializedHeaderSize+n*materializedEntrySize+namesSize+4)
+	le := binary.LittleEndian
+	copy(res, materializedMagic)
+	le.PutUint32(res[8:], materializedVersion)
+	if t.GoFuncs {
+		le.PutUint32(res[12:], materializedGoFuncs)
+	}
+	le.PutUint32(res[16:], uint32(n))
+	le.PutUint32(res[20:], uint32(namesSize))
+	off := 0
+	for i, name := range t.Names {
+		res = le.AppendUint64(res, t.Values.Get(i))
+		res = le.AppendUint64(res, t.Sizes.Get(i))
+		res = le.AppendUint32(res, uint32(off))
+		res = le.AppendUint32(res, uint32(len(name)))
+		off += len(name)
+	}
+	for _, name := range t.Names {
+		res = append(res, name...)
+	}
+	return le.AppendUint32(res, crc32.ChecksumIEEE(res)), nil
+}
+
+func (t *MaterializedTable) UnmarshalBinary(data []byte) error {
+	le := binary.LittleEndian
+	if len(data) < materializedHeaderSize+4 || string(data[:8]) != string(materializedMagic) ||
+		le.Uint32(data[8:]) != materializedVersion {
+		return ErrInvalidMaterializedTable
+	}
+	sum := le.Uint32(data[len(data)-4:])
+	data = data[:len(data)-4]
+	if crc32.ChecksumIEEE(data) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidMaterializedTable)
+	}
+	flags := le.Uint32(data[12:])
+	n, namesSize := uint64(le.Uint32(data[16:])), uint64(le.Uint32(data[20:]))
+	if uint64(len(data)) != materializedHeaderSize+n*materializedEntrySize+namesSize {
+		return fmt.Errorf("%w: size mismatch", ErrInvalidMaterializedTable)
+	}
+	entries := data[materializedHeaderSize:]
+	names := string(entries[n*materializedEntrySize:])
+
+	t.Values = gosym.NewPCIndex(int(n))
+	t.Sizes = gosym.NewPCIndex(int(n))
+	t.Names = make([]string, n)
+	t.GoFuncs = flags&materializedGoFuncs != 0
+	var prev uint64
+	for i := 0; i < int(n); i++ {
+		e := entries[i*materializedEntrySize:]
+		value, size := le.Uint64(e), le.Uint64(e[8:])
+		off, nameSize := uint64(le.Uint32(e[16:])), uint64(le.Uint32(e[20:]))
+		if value < prev || off+nameSize > namesSize {
+			return fmt.Errorf("%w: invalid entry %d", ErrInvalidMaterializedTable, i)
+		}
+		prev = value
+		t.Values.Set(i, value)
+		t.Sizes.Set(i, size)
+		t.Names[i] = names[off : off+nameSize]
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package elf
+
+import (
+	"testing"
+
+	"github.com/ianlancetaylor/demangle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterialize(t *testing.T) {
+	fs := []string{
+		"./testdata/elfs/elf",
+		"./testdata/elfs/elf.386",
+		"./testdata/elfs/rust.legacy",
+		"./testdata/elfs/go16",
+		"./testdata/elfs/go20",
+	}
+	for _, f := range fs {
+		t.Run(f, func(t *testing.T) {
+			me, err := NewMMapedElfFile(f)
+			require.NoError(t, err)
+			defer me.Close()
+
+			opt := &SymbolOptions{DemangleOpts: []demangle.Option{demangle.NoClones}}
+			symtab, err := me.NewSymbolTable(opt)
+			require.NoError(t, err)
+			var tab Table = symtab
+			if gotab, err := me.NewGoTable(symtab); err == nil {
+				tab = gotab
+			}
+
+			mt, err := Materialize(tab)
+			require.NoError(t, err)
+			_, isGo := tab.(*GoTable)
+			assert.Equal(t, isGo, mt.GoFuncs)
+
+			data, err := mt.MarshalBinary()
+			require.NoError(t, err)
+			res := &MaterializedTable{}
+			require.NoError(t, res.UnmarshalBinary(data))
+			assert.Equal(t, mt, res)
+
+			// every start, end and padding address resolves the same
+			for i := 0; i < res.Values.Length(); i++ {
+				start, size := res.Values.Get(i), res.Sizes.Get(i)
+				for _, addr := range []uint64{start, start + size - 1, start + size, start - 1} {
+					require.Equal(t, tab.Resolve(addr), res.Resolve(addr), "addr 0x%x", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestMaterializedTable_UnmarshalBinary(t *testing.T) {
+	data, err := (&MaterializedTable{Names: []string{}}).MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, new(MaterializedTable).UnmarshalBinary(data))
+
+	for _, invalid := range [][]byte{
+		nil,
+		data[:len(data)-1],
+		append([]byte("SYMTABv2"), data[8:]...),
+	} {
+		assert.ErrorIs(t, new(MaterializedTable).UnmarshalBinary(invalid), ErrInvalidMaterializedTable)
+	}
+}
@@ -0,0 +1,40 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+var errNoMiniDebugInfo = errors.New("no .gnu_debugdata")
+
+// MiniDebugInfo opens the xz compressed ELF file embedded in .gnu_debugdata.
+// Distributions such as Fedora strip binaries but keep their local function
+// symbols there, see https://sourceware.org/gdb/onlinedocs/gdb/MiniDebugInfo.html
+func (f *MMapedElfFile) MiniDebugInfo() (*MMapedElfFile, error) {
+	scn := f.Section(".gnu_debugdata")
+	if scn == nil || scn.Type == elf.SHT_NOBITS {
+		return nil, errNoMiniDebugInfo
+	}
+	compressed, err := f.SectionData(scn)
+	if err != nil {
+		return nil, fmt.Errorf("read .gnu_debugdata: %w", err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("xz .gnu_debugdata: %w", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress .gnu_debugdata: %w", err)
+	}
+	res, err := NewMemElfFile(f.fpath+"[.gnu_debugdata]", data)
+	if err != nil {
+		return nil, fmt.Errorf("open .gnu_debugdata: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,123 @@
+package elf
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+)
+
+// PLT stubs have no symbols, name@plt symbols are synthesized from the
+// .rela.plt relocations (.rel.plt on i386): the n'th stub jumps through the
+// GOT slot of the n'th relocation.
+
+var errNoPlt = errors.New("no plt")
+
+const (
+	rela64Size = 24
+	rel32Size  = 8
+)
+
+type pltLayout struct {
+	header    uint64 // size of PLT0, the lazy binding trampoline
+	entrySize uint64
+}
+
+// pltLayout returns the section of the PLT stubs and their layout, n is the
+// number of PLT relocations.
+func (f *MMapedElfFile) pltLayout(n uint64) (*elf.SectionHeader, pltLayout, bool) {
+	switch f.Machine {
+	case elf.EM_X86_64:
+		// With IBT the stubs called by the code live in .plt.sec, without a
+		// header, and .plt only keeps the lazy binding stubs.
+		if sec := f.Section(".plt.sec"); sec != nil {
+			return sec, pltLayout{header: 0, entrySize: 16}, true
+		}
+		if plt := f.Section(".plt"); plt != nil {
+			return plt, pltLayout{header: 16, entrySize: 16}, true
+		}
+	case elf.EM_AARCH64:
+		if plt := f.Section(".plt"); plt != nil {
+			// BTI and PAC stubs take 24 bytes instead of 16, the header is
+			// 32 bytes either way.
+			entrySize := uint64(16)
+			if n > 0 && plt.Size == 32+24*n {
+				entrySize = 24
+			}
+			return plt, pltLayout{header: 32, entrySize: entrySize}, true
+		}
+	case elf.EM_386:
+		if sec := f.Section(".plt.sec"); sec != nil {
+			return sec, pltLayout{header: 0, entrySize: 16}, true
+		}
+		if plt := f.Section(".plt"); plt != nil {
+			return plt, pltLayout{header: 16, entrySize: 16}, true
+		}
+	}
+	return nil, pltLayout{}, false
+}
+
+// getPltSymbols returns a symbol per PLT stub, named after the .dynsym entry
+// of its relocation, and the section index of the string table. The @plt
+// suffix is added by SymbolTable.
+func (f *MMapedElfFile) getPltSymbols(opt *SymbolOptions) ([]SymbolIndex, uint32, error) {
+	relName, relSize, symSize := ".rela.plt", uint64(rela64Size), uint64(elf.Sym64Size)
+	if f.Class == elf.ELFCLASS32 {
+		relName, relSize, symSize = ".rel.plt", rel32Size, elf.Sym32Size
+	}
+	rela := f.Section(relName)
+	if rela == nil {
+		return nil, 0, errNoPlt
+	}
+	plt, layout, ok := f.pltLayout(rela.Size / relSize)
+	if !ok || plt.Type == elf.SHT_NOBITS || int(rela.Link) >= len(f.Sections) {
+		return nil, 0, errNoPlt
+	}
+	symtab := &f.Sections[rela.Link]
+	if int(symtab.Link) >= len(f.Sections) {
+		return nil, 0, errNoPlt
+	}
+	relocs, err := f.SectionData(rela)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read %s: %w", relName, err)
+	}
+	dynsym, err := f.SectionData(symtab)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read %s symbols: %w", relName, err)
+	}
+
+	n := uint64(len(relocs)) / relSize
+	if plt.Size > layout.header {
+		n = min(n, (plt.Size-layout.header)/layout.entrySize)
+	} else {
+		n = 0
+	}
+	symbols := make([]SymbolIndex, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var symIdx uint64
+		if f.Class == elf.ELFCLASS32 {
+			// Elf32_Rel: r_offset, r_info
+			symIdx = uint64(f.ByteOrder.Uint32(relocs[i*relSize+4:]) >> 8)
+		} else {
+			// Elf64_Rela: r_offset, r_info, r_addend
+			symIdx = f.ByteOrder.Uint64(relocs[i*relSize+8:]) >> 32
+		}
+		// IRELATIVE relocations have no symbol
+		if symIdx == 0 || (symIdx+1)*symSize > uint64(len(dynsym)) {
+			continue
+		}
+		name := f.ByteOrder.Uint32(dynsym[symIdx*symSize:])
+		if name == 0 || name > maxNameIndex {
+			continue
+		}
+		pc := plt.Addr + layout.header + i*layout.entrySize
+		if pc >= opt.FilterFrom && pc < opt.FilterTo {
+			continue
+		}
+		symbols = append(symbols, SymbolIndex{
+			Name:  NewName(name, sectionTypePlt),
+			Value: pc,
+			Size:  layout.entrySize,
+		})
+	}
+	return symbols, symtab.Link, nil
+}
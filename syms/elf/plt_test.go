@@ -0,0 +1,69 @@
+package elf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolTable_Plt(t *testing.T) {
+	testcases := []struct {
+		fpath string
+		addrs map[uint64]string
+	}{
+		{
+			// x86_64 with IBT, the stubs are in .plt.sec
+			fpath: "./testdata/elfs/elf",
+			addrs: map[uint64]string{
+				0x1050: "lib_iter@plt",
+				0x105f: "lib_iter@plt",
+				0x1149: "iter",
+			},
+		},
+		{
+			fpath: "./testdata/elfs/elf.arm64",
+			addrs: map[uint64]string{
+				0x210370: "lib_iter@plt",
+				0x21038c: "lib_other@plt",
+				0x210338: "iter",
+				// PLT0
+				0x210350: "",
+			},
+		},
+		{
+			// arm64 with BTI, the stubs are 24 bytes
+			fpath: "./testdata/elfs/elf.arm64.bti",
+			addrs: map[uint64]string{
+				0x210370: "lib_iter@plt",
+				0x210384: "lib_iter@plt",
+				0x210388: "lib_other@plt",
+				0x21039c: "lib_other@plt",
+				0x210338: "iter",
+				// PLT0
+				0x210350: "",
+			},
+		},
+		{
+			fpath: "./testdata/elfs/elf.386",
+			addrs: map[uint64]string{
+				0x12a0: "lib_iter@plt",
+				0x12af: "lib_iter@plt",
+				0x124c: "iter",
+			},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.fpath, func(t *testing.T) {
+			me, err := NewMMapedElfFile(tt.fpath)
+			require.NoError(t, err)
+			defer me.Close()
+
+			tab, err := me.NewSymbolTable(new(SymbolOptions))
+			require.NoError(t, err)
+			for addr, name := range tt.addrs {
+				assert.Equal(t, name, tab.Resolve(addr), "addr 0x%x", addr)
+			}
+		})
+	}
+}
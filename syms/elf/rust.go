@@ -0,0 +1,53 @@
+package elf
+
+import (
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// Rust symbols use either the legacy mangling, an Itanium-like name ending
+// with a 17h<16 hex digits>E hash segment, or the v0 mangling, starting
+// with _R. v0 names encode generic arguments and have no trailing hash.
+
+const rustHashLen = 16
+
+// demangleName demangles C++ and Rust names. The hash of Rust legacy names is
+// stripped unless keepRustHash is set, it then follows the path as in
+// rustc-demangle: path::h0123456789abcdef.
+func demangleName(name string, options []demangle.Option, keepRustHash bool) string {
+	res := demangle.Filter(name, options...)
+	if !keepRustHash || res == name {
+		return res
+	}
+	if hash := rustLegacyHash(name); hash != "" {
+		return res + "::h" + hash
+	}
+	return res
+}
+
+// rustLegacyHash returns the hash of a Rust legacy name, or "" if name is not
+// one.
+func rustLegacyHash(name string) string {
+	if !strings.HasPrefix(name, "_ZN") {
+		return ""
+	}
+	// compiler suffixes such as .llvm.1234 follow the mangled name
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		name = name[:dot]
+	}
+	if !strings.HasSuffix(name, "E") {
+		return ""
+	}
+	name = name[:len(name)-1]
+	if len(name) < rustHashLen+3 || name[len(name)-rustHashLen-3:len(name)-rustHashLen] != "17h" {
+		return ""
+	}
+	hash := name[len(name)-rustHashLen:]
+	for i := 0; i < len(hash); i++ {
+		if !('0' <= hash[i] && hash[i] <= '9' || 'a' <= hash[i] && hash[i] <= 'f') {
+			return ""
+		}
+	}
+	return hash
+}
@@ -0,0 +1,88 @@
+package elf
+
+import (
+	"testing"
+
+	"github.com/ianlancetaylor/demangle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolTable_Rust(t *testing.T) {
+	testcases := []struct {
+		name  string
+		fpath string
+		opt   SymbolOptions
+		addrs map[uint64]string
+	}{
+		{
+			name:  "legacy",
+			fpath: "./testdata/elfs/rust.legacy",
+			opt:   SymbolOptions{DemangleOpts: []demangle.Option{demangle.NoClones}},
+			addrs: map[uint64]string{
+				0x15a0: "rust::iter",
+				0x1580: "rust::Point<T>::sum",
+				0x1590: "rust::Point<T>::sum",
+				0x15f0: "rust_main",
+			},
+		},
+		{
+			name:  "legacy with hash",
+			fpath: "./testdata/elfs/rust.legacy",
+			opt:   SymbolOptions{DemangleOpts: []demangle.Option{demangle.NoClones}, KeepRustHash: true},
+			addrs: map[uint64]string{
+				0x15a0: "rust::iter::hd29e73094b59ca81",
+				0x1580: "rust::Point<T>::sum::h7a3da0b2dfcb4f1b",
+				0x1590: "rust::Point<T>::sum::h811589b73ed42ec4",
+				0x15f0: "rust_main",
+			},
+		},
+		{
+			name:  "legacy not demangled",
+			fpath: "./testdata/elfs/rust.legacy",
+			opt:   SymbolOptions{KeepRustHash: true},
+			addrs: map[uint64]string{
+				0x15a0: "_ZN4rust4iter17hd29e73094b59ca81E",
+			},
+		},
+		{
+			name:  "v0",
+			fpath: "./testdata/elfs/rust.v0",
+			opt:   SymbolOptions{DemangleOpts: []demangle.Option{demangle.NoClones}, KeepRustHash: true},
+			addrs: map[uint64]string{
+				0x15a0: "rust::iter",
+				0x1580: "<rust::Point<u32>>::sum",
+				0x1590: "<rust::Point<u64>>::sum",
+				0x15f0: "rust_main",
+			},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			me, err := NewMMapedElfFile(tt.fpath)
+			require.NoError(t, err)
+			defer me.Close()
+
+			tab, err := me.NewSymbolTable(&tt.opt)
+			require.NoError(t, err)
+			for addr, name := range tt.addrs {
+				assert.Equal(t, name, tab.Resolve(addr), "addr 0x%x", addr)
+			}
+		})
+	}
+}
+
+func TestRustLegacyHash(t *testing.T) {
+	testcases := map[string]string{
+		"_ZN4rust4iter17hd29e73094b59ca81E":            "d29e73094b59ca81",
+		"_ZN4rust4iter17hd29e73094b59ca81E.llvm.12345": "d29e73094b59ca81",
+		// not a hash
+		"_ZN4rust4iter17hd29e73094b59ca8gE": "",
+		"_ZN4rust4iterE":                    "",
+		"_ZN3foo3barEv":                     "",
+		"_RNvCs76YBrTD2x10_4rust4iter":      "",
+	}
+	for name, hash := range testcases {
+		assert.Equal(t, hash, rustLegacyHash(name), name)
+	}
+}
@@ -15,6 +15,7 @@ import (
 type SymbolIndex struct {
 	Name  Name
 	Value uint64
+	Size  uint64
 }
 
 type SectionLinkIndex uint8
@@ -22,36 +23,52 @@ type SectionLinkIndex uint8
 var (
 	sectionTypeSym    SectionLinkIndex = 0
 	sectionTypeDynSym SectionLinkIndex = 1
+	// .symtab of the MiniDebugInfo, its string table lives in SymbolTable.MiniDebug
+	sectionTypeMiniDebugSym SectionLinkIndex = 2
+	// synthesized name@plt symbols, named from the .dynstr
+	sectionTypePlt SectionLinkIndex = 3
 )
 
+// Name packs a 30-bit string table index with a 2-bit link index.
 type Name uint32
 
+const maxNameIndex = 0x3fffffff
+
 func NewName(NameIndex uint32, linkIndex SectionLinkIndex) Name {
-	return Name((NameIndex & 0x7fffffff) | uint32(linkIndex)<<31)
+	return Name((NameIndex & maxNameIndex) | uint32(linkIndex)<<30)
 }
 
 func (n *Name) NameIndex() uint32 {
-	return uint32(*n) & 0x7fffffff
+	return uint32(*n) & maxNameIndex
 }
 
 func (n *Name) LinkIndex() SectionLinkIndex {
-	return SectionLinkIndex(*n >> 31)
+	return SectionLinkIndex(*n >> 30)
 }
 
 type FlatSymbolIndex struct {
 	Links  []elf.SectionHeader
 	Names  []Name
 	Values gosym.PCIndex
+	// st_size of each symbol, 0 if unknown
+	Sizes gosym.PCIndex
 }
 type SymbolTable struct {
 	Index FlatSymbolIndex
 	File  *MMapedElfFile
+	// MiniDebug is the in-memory file of .gnu_debugdata, nil if its symbols
+	// were not merged
+	MiniDebug *MMapedElfFile
+	// DebugFile is the debug file holding the .symtab, nil if it is read
+	// from File
+	DebugFile *MMapedElfFile
 
 	demangleOptions []demangle.Option
+	keepRustHash    bool
 }
 
 func (st *SymbolTable) IsDead() bool {
-	return st.File.err != nil
+	return st.File.failed()
 }
 
 func (st *SymbolTable) DebugInfo() SymTabDebugInfo {
@@ -73,36 +90,95 @@ func (st *SymbolTable) DebugString() string {
 }
 
 func (st *SymbolTable) Resolve(addr uint64) string {
+	name, _ := st.ResolveStart(addr)
+	return name
+}
+
+func (st *SymbolTable) ResolveStart(addr uint64) (string, uint64) {
 	if len(st.Index.Names) == 0 {
-		return ""
+		return "", 0
 	}
 	i := st.Index.Values.FindIndex(addr)
 	if i == -1 {
-		return ""
+		return "", 0
 	}
-	name, _ := st.symbolName(i)
-	return name
+	// Symbols without a size (often hand written assembly) extend up to the
+	// next symbol. Otherwise addr must be inside one of the symbols starting
+	// at the same address, it may be in padding or unnamed code.
+	start := st.Index.Values.Get(i)
+	for ; i < len(st.Index.Names) && st.Index.Values.Get(i) == start; i++ {
+		if size := st.Index.Sizes.Get(i); size == 0 || addr < start+size {
+			name, _ := st.symbolName(i)
+			return name, start
+		}
+	}
+	return "", 0
 }
 
-func (st *SymbolTable) Cleanup() { st.File.Close() }
+func (st *SymbolTable) Cleanup() {
+	st.File.Close()
+	if st.DebugFile != nil {
+		st.DebugFile.Close()
+	}
+}
 
 func (f *MMapedElfFile) NewSymbolTable(opt *SymbolOptions) (*SymbolTable, error) {
-	sym, sectionSym, err := f.getSymbols(elf.SHT_SYMTAB, opt)
-	if err != nil && !errors.Is(err, ErrNoSymbols) {
-		return nil, err
+	// The .symtab of a debug file is a superset of the one of the file. Its
+	// .dynsym and .plt are NOBITS, they are read from the file.
+	symFile, debugFile := f, opt.DebugFile
+	var (
+		sym        []SymbolIndex
+		sectionSym uint32
+		err        error
+	)
+	if debugFile != nil {
+		sym, sectionSym, err = debugFile.getSymbols(elf.SHT_SYMTAB, opt)
+		if err == nil && len(sym) > 0 {
+			symFile = debugFile
+		} else {
+			debugFile = nil
+		}
+	}
+	if debugFile == nil {
+		sym, sectionSym, err = f.getSymbols(elf.SHT_SYMTAB, opt)
+		if err != nil && !errors.Is(err, ErrNoSymbols) {
+			return nil, err
+		}
 	}
 
 	dynsym, sectionDynSym, err := f.getSymbols(elf.SHT_DYNSYM, opt)
 	if err != nil && !errors.Is(err, ErrNoSymbols) {
 		return nil, err
 	}
-	total := len(dynsym) + len(sym)
+
+	// stripped binaries may keep their local symbols in the MiniDebugInfo
+	var (
+		mini           *MMapedElfFile
+		minisym        []SymbolIndex
+		sectionMiniSym elf.SectionHeader
+		miniErr        error
+	)
+	if debugFile == nil && f.sectionByType(elf.SHT_SYMTAB) == nil {
+		mini, minisym, sectionMiniSym, miniErr = f.miniDebugSymbols(opt)
+	}
+
+	plt, sectionPlt, err := f.getPltSymbols(opt)
+	if err != nil && !errors.Is(err, errNoPlt) {
+		return nil, err
+	}
+
+	total := len(dynsym) + len(sym) + len(minisym) + len(plt)
 	if total == 0 {
+		if miniErr != nil && !errors.Is(miniErr, errNoMiniDebugInfo) {
+			return nil, miniErr
+		}
 		return nil, ErrNoSymbols
 	}
 	all := make([]SymbolIndex, 0, total) // todo avoid allocation
 	all = append(all, sym...)
 	all = append(all, dynsym...)
+	all = append(all, minisym...)
+	all = append(all, plt...)
 
 	sort.Slice(all, func(i, j int) bool {
 		if all[i].Value == all[j].Value {
@@ -114,30 +190,67 @@ func (f *MMapedElfFile) NewSymbolTable(opt *SymbolOptions) (*SymbolTable, error)
 	res := &SymbolTable{
 		Index: FlatSymbolIndex{
 			Links: []elf.SectionHeader{
-				f.Sections[sectionSym],    // should be at 0 - SectionTypeSym
-				f.Sections[sectionDynSym], // should be at 1 - SectionTypeDynSym
+				symFile.Sections[sectionSym], // should be at 0 - SectionTypeSym
+				f.Sections[sectionDynSym],    // should be at 1 - SectionTypeDynSym
+				sectionMiniSym,               // should be at 2 - SectionTypeMiniDebugSym
+				f.Sections[sectionPlt],       // should be at 3 - SectionTypePlt
 			},
 			Names:  make([]Name, total),
 			Values: gosym.NewPCIndex(total),
+			Sizes:  gosym.NewPCIndex(total),
 		},
 		File:            f,
+		MiniDebug:       mini,
+		DebugFile:       debugFile,
 		demangleOptions: opt.DemangleOpts,
+		keepRustHash:    opt.KeepRustHash,
 	}
 	for i := range all {
 		res.Index.Names[i] = all[i].Name
 		res.Index.Values.Set(i, all[i].Value)
+		res.Index.Sizes.Set(i, all[i].Size)
 	}
 	return res, nil
 }
 
+// miniDebugSymbols returns the function symbols of the MiniDebugInfo with
+// the header of their string table.
+func (f *MMapedElfFile) miniDebugSymbols(opt *SymbolOptions) (*MMapedElfFile, []SymbolIndex, elf.SectionHeader, error) {
+	mini, err := f.MiniDebugInfo()
+	if err != nil {
+		return nil, nil, elf.SectionHeader{}, err
+	}
+	symbols, link, err := mini.getSymbols(elf.SHT_SYMTAB, opt)
+	if err != nil {
+		return nil, nil, elf.SectionHeader{}, fmt.Errorf("minidebuginfo symbols: %w", err)
+	}
+	if int(link) >= len(mini.Sections) {
+		return nil, nil, elf.SectionHeader{}, fmt.Errorf("minidebuginfo symbols: invalid link %d", link)
+	}
+	for i := range symbols {
+		symbols[i].Name = NewName(symbols[i].Name.NameIndex(), sectionTypeMiniDebugSym)
+	}
+	return mini, symbols, mini.Sections[link], nil
+}
+
 func (st *SymbolTable) symbolName(idx int) (string, error) {
 	linkIndex := st.Index.Names[idx].LinkIndex()
 	SectionHeaderLink := &st.Index.Links[linkIndex]
 	NameIndex := st.Index.Names[idx].NameIndex()
-	s, b := st.File.getString(int(NameIndex)+int(SectionHeaderLink.Offset), st.demangleOptions)
+	file := st.File
+	switch {
+	case linkIndex == sectionTypeMiniDebugSym:
+		file = st.MiniDebug
+	case linkIndex == sectionTypeSym && st.DebugFile != nil:
+		file = st.DebugFile
+	}
+	s, b := file.getString(int(NameIndex)+int(SectionHeaderLink.Offset), st.demangleOptions, st.keepRustHash)
 	if !b {
 		return "", fmt.Errorf("elf getString")
 	}
+	if linkIndex == sectionTypePlt {
+		s += "@plt"
+	}
 	return s, nil
 }
 
@@ -12,6 +12,10 @@ func TestName(t *testing.T) {
 	name := NewName(0xef, 1)
 	require.Equal(t, uint32(0xef), name.NameIndex())
 	require.Equal(t, SectionLinkIndex(1), name.LinkIndex())
+
+	name = NewName(maxNameIndex, sectionTypeMiniDebugSym)
+	require.Equal(t, uint32(maxNameIndex), name.NameIndex())
+	require.Equal(t, sectionTypeMiniDebugSym, name.LinkIndex())
 }
 
 func Test_SymbolTable(t *testing.T) {
@@ -33,8 +37,14 @@ func Test_SymbolTable(t *testing.T) {
 			}{
 				{0x00001149, "iter"},
 				{0x0000115e, "main"},
+				// padding past the end of _start and main
+				{0x00001088, ""},
+				{0x00001172, ""},
+				// no size, extends up to the next symbol
+				{0x000010a0, "deregister_tm_clones"},
+				{0x00001050, "lib_iter@plt"},
 			},
-			size: 9,
+			size: 10,
 		},
 		{
 			name:  "test with go20 file",
@@ -47,6 +57,69 @@ func Test_SymbolTable(t *testing.T) {
 			},
 			size: 1448,
 		},
+		{
+			name:  "test with minidebuginfo",
+			fpath: "./testdata/elfs/elf.minidebug",
+			addrs: []struct {
+				addr   uint64
+				symbol string
+			}{
+				{0x00001129, "outer"},
+				{0x0000114f, "main"},
+				{0x00001040, "_start"},
+			},
+			size: 9,
+		},
+		{
+			name:  "test with elf32 file",
+			fpath: "./testdata/elfs/elf.386",
+			addrs: []struct {
+				addr   uint64
+				symbol string
+			}{
+				{0x0000124c, "iter"},
+				{0x00001269, "iter"},
+				{0x0000126a, "_start"},
+				{0x000012a0, "lib_iter@plt"},
+				// padding past the end of the plt
+				{0x000012b0, ""},
+			},
+			size: 4,
+		},
+		{
+			name:  "test with SO32 file",
+			fpath: "./testdata/elfs/libexample32.so.1",
+			addrs: []struct {
+				addr   uint64
+				symbol string
+			}{
+				{0x000011f4, "lib_iter"},
+				{0x00001204, "__x86.get_pc_thunk.ax"},
+			},
+			size: 3,
+		},
+		{
+			name:  "test with go 386 file",
+			fpath: "./testdata/elfs/go21-386",
+			addrs: []struct {
+				addr   uint64
+				symbol string
+			}{
+				{0x080c5460, "main.main"},
+			},
+			size: 1535,
+		},
+		{
+			name:  "test with big endian go file",
+			fpath: "./testdata/elfs/go21-s390x",
+			addrs: []struct {
+				addr   uint64
+				symbol string
+			}{
+				{0x000a6730, "main.main"},
+			},
+			size: 1429,
+		},
 		{
 			name:  "test with SO file",
 			fpath: "./testdata/elfs/libexample.so",
@@ -74,3 +147,40 @@ func Test_SymbolTable(t *testing.T) {
 		})
 	}
 }
+
+func TestSymbolTable_DebugFile(t *testing.T) {
+	newTable := func(path, debugPath string) *SymbolTable {
+		e, err := NewMMapedElfFile(path)
+		require.NoError(t, err)
+		opts := &SymbolOptions{}
+		if debugPath != "" {
+			opts.DebugFile, err = NewMMapedElfFile(debugPath)
+			require.NoError(t, err)
+		}
+		symtbl, err := e.NewSymbolTable(opts)
+		require.NoError(t, err)
+		t.Cleanup(symtbl.Cleanup)
+		return symtbl
+	}
+
+	stripped := newTable("./testdata/elfs/elf.stripped", "")
+	assert.Equal(t, "", stripped.Resolve(0x1149))
+	assert.Equal(t, "lib_iter@plt", stripped.Resolve(0x1050))
+
+	// the .symtab of the debug file is merged with the .dynsym and the PLT
+	merged := newTable("./testdata/elfs/elf.stripped", "./testdata/elfs/elf.debug")
+	require.NotNil(t, merged.DebugFile)
+	assert.Equal(t, "iter", merged.Resolve(0x1149))
+	assert.Equal(t, "main", merged.Resolve(0x115e))
+	name, start := merged.ResolveStart(0x1150)
+	assert.Equal(t, "iter", name)
+	assert.Equal(t, uint64(0x1149), start)
+	assert.Equal(t, "lib_iter@plt", merged.Resolve(0x1050))
+	assert.Equal(t, stripped.Size()+newTable("./testdata/elfs/elf.debug", "").Size(), merged.Size())
+
+	// debug files without symbols are ignored
+	nosyms := newTable("./testdata/elfs/elf.stripped", "./testdata/elfs/elf.stripped")
+	assert.Nil(t, nosyms.DebugFile)
+	assert.Equal(t, stripped.Size(), nosyms.Size())
+	assert.Equal(t, "lib_iter@plt", nosyms.Resolve(0x1050))
+}
@@ -4,6 +4,45 @@ set -ex
 gcc lib.c -o libexample.so -shared
 gcc src.c -o elf -lexample -L. -Wl,-rpath=.
 gcc src.c -no-pie -o elf.nopie -lexample -L. -Wl,-rpath=.
+gcc -g src.c -o elf.dwarf -lexample -L. -Wl,-rpath=.
+objcopy --only-keep-debug elf.dwarf elf.dwarf.debug
+strip elf.dwarf -o elf.dwarf.stripped
+gcc -g -O0 inline.c -o elf.inline
+
+# arm64 PLT, assembled as there is no cross compiler in the image
+llvm-mc -triple=aarch64-linux-gnu -filetype=obj plt_lib.s -o plt_lib.o
+llvm-mc -triple=aarch64-linux-gnu -filetype=obj plt_main.s -o plt_main.o
+ld.lld -shared plt_lib.o -o libplt.so -soname libplt.so
+ld.lld plt_main.o libplt.so -o elf.arm64 --dynamic-linker /lib/ld-linux-aarch64.so.1
+ld.lld -z force-bti plt_main.o libplt.so -o elf.arm64.bti --dynamic-linker /lib/ld-linux-aarch64.so.1
+
+# i386, freestanding as there is no 32-bit libc in the image. lld does not
+# page align the executable segment.
+gcc -m32 -O0 -fPIC -c i386_lib.c -o i386_lib.o
+gcc -m32 -O0 -fPIE -c i386_main.c -o i386_main.o
+ld.lld -m elf_i386 -shared i386_lib.o -o libexample32.so.1 -soname libexample32.so.1
+ld.lld -m elf_i386 -pie i386_main.o libexample32.so.1 -o elf.386 --dynamic-linker /lib/ld-linux.so.2
+
+# no section headers: clear e_shoff, e_shnum and e_shstrndx
+strip_section_headers() {
+	printf '\0\0\0\0\0\0\0\0' | dd of="$1" bs=1 seek=40 conv=notrunc
+	printf '\0\0\0\0' | dd of="$1" bs=1 seek=60 conv=notrunc
+}
+gcc lib.c -o elf.nosections -shared -Wl,--hash-style=gnu
+strip_section_headers elf.nosections
+gcc lib.c -o elf.nosections.sysv -shared -Wl,--hash-style=sysv
+strip_section_headers elf.nosections.sysv
+
+# MiniDebugInfo, same steps as find-debuginfo
+gcc -O0 inline.c -o elf.minidebug
+nm -D elf.minidebug --format=posix --defined-only | awk '{ print $1 }' | sort > dynsyms
+nm elf.minidebug --format=posix --defined-only | awk '{ if ($2 == "T" || $2 == "t" || $2 == "D") print $1 }' | sort > funcsyms
+comm -13 dynsyms funcsyms > keep_symbols
+objcopy --only-keep-debug elf.minidebug mini_debuginfo
+objcopy -S --remove-section .gdb_index --remove-section .comment --keep-symbols=keep_symbols mini_debuginfo
+strip --strip-all -R .comment elf.minidebug
+xz mini_debuginfo
+objcopy --add-section .gnu_debugdata=mini_debuginfo.xz elf.minidebug
 objcopy --only-keep-debug elf elf.debug
 strip elf -o elf.stripped
 objcopy --add-gnu-debuglink=elf.debug elf.stripped elf.debuglink
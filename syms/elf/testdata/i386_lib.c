@@ -0,0 +1,2 @@
+void lib_iter(void) {
+}
@@ -0,0 +1,12 @@
+/* Freestanding, there is no 32-bit libc in the image. */
+void lib_iter(void);
+
+void iter(void) {
+	lib_iter();
+}
+
+void _start(void) {
+	while (1) {
+		iter();
+	}
+}
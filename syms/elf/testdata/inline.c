@@ -0,0 +1,20 @@
+volatile int sink;
+
+static inline __attribute__((always_inline)) void leaf(int v) {
+	sink = v;
+}
+
+static inline __attribute__((always_inline)) void middle(int v) {
+	leaf(v + 1);
+}
+
+void outer(int v) {
+	middle(v * 2);
+}
+
+int main() {
+	while (1) {
+		outer(sink);
+	}
+	return 0;
+}
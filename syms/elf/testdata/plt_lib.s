@@ -0,0 +1,11 @@
+	.text
+	.globl lib_iter
+	.type lib_iter, %function
+lib_iter:
+	ret
+	.size lib_iter, .-lib_iter
+	.globl lib_other
+	.type lib_other, %function
+lib_other:
+	ret
+	.size lib_other, .-lib_other
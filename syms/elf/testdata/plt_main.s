@@ -0,0 +1,14 @@
+	.text
+	.globl _start
+	.type _start, %function
+_start:
+	bl iter
+	b _start
+	.size _start, .-_start
+	.globl iter
+	.type iter, %function
+iter:
+	bl lib_iter
+	bl lib_other
+	ret
+	.size iter, .-iter
@@ -0,0 +1,30 @@
+#![no_std]
+
+use core::panic::PanicInfo;
+
+pub struct Point<T> {
+    x: T,
+    y: T,
+}
+
+impl<T: Copy + core::ops::Add<Output = T>> Point<T> {
+    #[inline(never)]
+    pub fn sum(&self) -> T {
+        self.x + self.y
+    }
+}
+
+#[inline(never)]
+fn iter(n: u64) -> u64 {
+    Point { x: n, y: 1 }.sum() + Point { x: n as u32, y: 2 }.sum() as u64
+}
+
+#[no_mangle]
+pub extern "C" fn rust_main(n: u64) -> u64 {
+    iter(n)
+}
+
+#[panic_handler]
+fn panic(_: &PanicInfo) -> ! {
+    loop {}
+}
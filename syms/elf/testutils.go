@@ -63,7 +63,34 @@ func GetGoSymbols(file string, patchGo20Magic bool) ([]TestSym, error) {
 	return symbols, nil
 }
 
+// GetGoTable returns the debug/gosym table of a Go binary.
+func GetGoTable(file string, patchGo20Magic bool) (*gosym.Table, error) {
+	obj, err := elf.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open elf file: %w", err)
+	}
+	defer obj.Close()
+	return newGoTableFromPCLN(obj, patchGo20Magic)
+}
+
 func getGoSymbolsFromPCLN(obj *elf.File, patchGo20Magic bool) ([]TestSym, error) {
+	table, err := newGoTableFromPCLN(obj, patchGo20Magic)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.Funcs) == 0 {
+		return nil, errors.New("gosymtab: no symbols found")
+	}
+
+	es := make([]TestSym, 0, len(table.Funcs))
+	for _, fun := range table.Funcs {
+		es = append(es, TestSym{Start: fun.Entry, Name: fun.Name})
+	}
+
+	return es, nil
+}
+
+func newGoTableFromPCLN(obj *elf.File, patchGo20Magic bool) (*gosym.Table, error) {
 	var err error
 	var pclntab []byte
 	text := obj.Section(".text")
@@ -96,18 +123,5 @@ func getGoSymbolsFromPCLN(obj *elf.File, patchGo20Magic bool) ([]TestSym, error)
 		}
 	}
 	pcln := gosym.NewLineTable(pclntab, textStart)
-	table, err := gosym.NewTable(nil, pcln)
-	if err != nil {
-		return nil, err
-	}
-	if len(table.Funcs) == 0 {
-		return nil, errors.New("gosymtab: no symbols found")
-	}
-
-	es := make([]TestSym, 0, len(table.Funcs))
-	for _, fun := range table.Funcs {
-		es = append(es, TestSym{Start: fun.Entry, Name: fun.Name})
-	}
-
-	return es, nil
+	return gosym.NewTable(nil, pcln)
 }
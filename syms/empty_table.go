@@ -2,7 +2,8 @@ package syms
 
 type emptyTable struct{}
 
-func (*emptyTable) Resolve(uint64) string { return "" }
-func (*emptyTable) Cleanup()              {}
-func (*emptyTable) IsDead() bool          { return false }
-func (*emptyTable) Size() int             { return 0 }
+func (*emptyTable) Resolve(uint64) string                { return "" }
+func (*emptyTable) ResolveStart(uint64) (string, uint64) { return "", 0 }
+func (*emptyTable) Cleanup()                             {}
+func (*emptyTable) IsDead() bool                         { return false }
+func (*emptyTable) Size() int                            { return 0 }
@@ -0,0 +1,156 @@
+package gosym
+
+// Inline tree decoding, following the inlineUnwinder of
+// https://github.com/golang/go/blob/go1.21.0/src/runtime/symtabinl.go
+
+const (
+	pcdataInlTreeIndex = 2
+	funcdataInlTree    = 3
+
+	// bound the walk in case of a malformed inline tree
+	maxInlineDepth = 64
+)
+
+// Frame is a logical frame of a pc. Functions inlined at the pc get their
+// own frames.
+type Frame struct {
+	Name string
+	File string
+	Line int
+}
+
+// PCToFrames returns the logical frames of pc, innermost first. fn is the
+// index of the function containing pc, as found with FlatFuncIndex.Entry.
+// Inlined frames are expanded for Go 1.16+ when FuncData is set, otherwise
+// a single frame is returned. It returns nil if the table is malformed.
+func (t *LineTable) PCToFrames(fn int, pc uint64) (res []Frame) {
+	if !disableRecover {
+		defer func() {
+			if recover() != nil {
+				res = nil
+			}
+		}()
+	}
+	t.parsePclnTab()
+	if t.version < ver12 || fn < 0 || fn >= int(t.nfunctab) {
+		return nil
+	}
+	f := t.funcData(uint32(fn))
+	entry := t.funcTab().pc(fn)
+
+	var tree uint64
+	if t.FuncData != nil && t.version >= ver116 {
+		tree = f.funcdata(funcdataInlTree)
+	}
+	for i := 0; i < maxInlineDepth; i++ {
+		ix := int32(-1)
+		if tree != 0 {
+			ix = f.pcdataValue(pcdataInlTreeIndex, entry, pc)
+		}
+		frame := Frame{
+			File: t.pcfile(f, entry, pc),
+			Line: int(t.pcvalue(f.pcln(), entry, pc)),
+		}
+		if ix < 0 {
+			frame.Name = t.funcName(f.nameOff())
+			return append(res, frame)
+		}
+		nameOff, parentPc := t.inlinedCall(tree, ix)
+		frame.Name = t.funcName(nameOff)
+		res = append(res, frame)
+		// the instruction at parentPc carries the position of the call site
+		pc = entry + uint64(parentPc)
+	}
+	return res
+}
+
+// inlinedCall returns the name offset and the parent pc of the ix'th
+// entry of the inline tree at address tree.
+func (t *LineTable) inlinedCall(tree uint64, ix int32) (uint32, int32) {
+	// Go 1.20+:
+	//	type inlinedCall struct {
+	//		funcID    abi.FuncID
+	//		_         [3]byte
+	//		nameOff   int32
+	//		parentPc  int32
+	//		startLine int32
+	//	}
+	// Go 1.16 - 1.19:
+	//	type inlinedCall struct {
+	//		parent   int16
+	//		funcID   funcID
+	//		_        byte
+	//		file     int32
+	//		line     int32
+	//		func_    int32
+	//		parentPc int32
+	//	}
+	size, nameAt := 20, 12
+	if t.version >= ver120 {
+		size, nameAt = 16, 4
+	}
+	var buf [20]byte
+	if err := t.FuncData.ReadAt(buf[:size], int(tree)+int(ix)*size); err != nil {
+		panic(err)
+	}
+	return t.binary.Uint32(buf[nameAt:]), int32(t.binary.Uint32(buf[nameAt+4:]))
+}
+
+// headerSize returns the size of the fixed part of the _func struct, which
+// is followed by the pcdata and funcdata arrays.
+func (f funcData) headerSize() uint64 {
+	switch {
+	case f.t.version >= ver120:
+		return 4 + 10*4 // startLine was added
+	case f.t.version >= ver118:
+		return 4 + 9*4 // entry became an uint32 offset
+	default:
+		return uint64(f.t.ptrsize) + 9*4
+	}
+}
+
+func (f funcData) base() uint64 { return f.t.funcdataOffset + f.dataOffset }
+
+func (f funcData) nfuncdata() uint8 {
+	// nfuncdata is the last byte of the header
+	var buf [1]byte
+	data := buf[:]
+	if err := f.t.PCLNData.ReadAt(data, int(f.base()+f.headerSize()-1)); err != nil {
+		panic(err)
+	}
+	return data[0]
+}
+
+// pcdataValue returns the value of the pcdata table at pc, -1 if the
+// function has no such table.
+func (f funcData) pcdataValue(table uint32, entry, pc uint64) int32 {
+	if table >= f.npcdata() {
+		return -1
+	}
+	off := f.t.uint32At(int(f.base() + f.headerSize() + uint64(table)*4))
+	if off == 0 {
+		return -1
+	}
+	return f.t.pcvalue(off, entry, pc)
+}
+
+// funcdata returns the address of the i'th funcdata of the function, 0 if
+// it has none.
+func (f funcData) funcdata(i uint8) uint64 {
+	if i >= f.nfuncdata() {
+		return 0
+	}
+	at := f.base() + f.headerSize() + uint64(f.npcdata())*4
+	if f.t.version >= ver118 {
+		off := f.t.uint32At(int(at + uint64(i)*4))
+		if off == ^uint32(0) || f.t.GoFunc == 0 {
+			return 0
+		}
+		return f.t.GoFunc + uint64(off)
+	}
+	// before Go 1.18 funcdata are pointers, aligned to the pointer size
+	if f.t.ptrsize == 8 && at&4 != 0 {
+		at += 4
+	}
+	return f.t.uintptrAt(int(at + uint64(i)*uint64(f.t.ptrsize)))
+}
@@ -6,28 +6,104 @@ func ParseRuntimeTextFromPclntab18(pclntab []byte) uint64 {
 	if len(pclntab) < 64 {
 		return 0
 	}
-	magic := binary.LittleEndian.Uint32(pclntab[0:4])
-	if magic == 0xFFFFFFF0 || magic == 0xFFFFFFF1 {
-		// https://github.com/golang/go/blob/go1.18/src/runtime/symtab.go#L395
-		// 0xFFFFFFF1 is the same
-		// https://github.com/golang/go/commit/0f8dffd0aa71ed996d32e77701ac5ec0bc7cde01
-		//type pcHeader struct {
-		//	magic          uint32  // 0xFFFFFFF0
-		//	pad1, pad2     uint8   // 0,0
-		//	minLC          uint8   // min instruction size
-		//	ptrSize        uint8   // size of a ptr in bytes
-		//	nfunc          int     // number of functions in the module
-		//	nfiles         uint    // number of entries in the file tab
-		//	textStart      uintptr // base for function entry PC offsets in this module, equal to moduledata.text
-		//	funcnameOffset uintptr // offset to the funcnametab variable from pcHeader
-		//	cuOffset       uintptr // offset to the cutab variable from pcHeader
-		//	filetabOffset  uintptr // offset to the filetab variable from pcHeader
-		//	pctabOffset    uintptr // offset to the pctab variable from pcHeader
-		//	pclnOffset     uintptr // offset to the pclntab variable from pcHeader
-		//}
-		textStart := binary.LittleEndian.Uint64(pclntab[24:32])
-		return textStart
+	// the header is written in the byte order of the target
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(pclntab) == go118magic, binary.LittleEndian.Uint32(pclntab) == go120magic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(pclntab) == go118magic, binary.BigEndian.Uint32(pclntab) == go120magic:
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	// https://github.com/golang/go/blob/go1.18/src/runtime/symtab.go#L395
+	// 0xFFFFFFF1 is the same
+	// https://github.com/golang/go/commit/0f8dffd0aa71ed996d32e77701ac5ec0bc7cde01
+	//type pcHeader struct {
+	//	magic          uint32  // 0xFFFFFFF0
+	//	pad1, pad2     uint8   // 0,0
+	//	minLC          uint8   // min instruction size
+	//	ptrSize        uint8   // size of a ptr in bytes
+	//	nfunc          int     // number of functions in the module
+	//	nfiles         uint    // number of entries in the file tab
+	//	textStart      uintptr // base for function entry PC offsets in this module, equal to moduledata.text
+	//	funcnameOffset uintptr // offset to the funcnametab variable from pcHeader
+	//	cuOffset       uintptr // offset to the cutab variable from pcHeader
+	//	filetabOffset  uintptr // offset to the filetab variable from pcHeader
+	//	pctabOffset    uintptr // offset to the pctab variable from pcHeader
+	//	pclnOffset     uintptr // offset to the pclntab variable from pcHeader
+	//}
+	// textStart follows the two pointer sized counts
+	ptrSize := int(pclntab[7])
+	at := 8 + 2*ptrSize
+	switch ptrSize {
+	case 4:
+		return uint64(order.Uint32(pclntab[at:]))
+	case 8:
+		return order.Uint64(pclntab[at:])
 	}
-
 	return 0
 }
+
+// FindPclntab returns the offset of the first Go 1.16+ pcHeader in data, or
+// -1. It is used for binaries that lost their .gopclntab section while the
+// table itself remains in .rodata or .data.rel.ro. Candidates are validated:
+// the header offsets must be ordered and the function table must fit in
+// data.
+func FindPclntab(data []byte) int {
+	for off := 0; off+8 <= len(data); off += 4 {
+		if validPclntabHeader(data[off:]) {
+			return off
+		}
+	}
+	return -1
+}
+
+func validPclntabHeader(data []byte) bool {
+	if data[4] != 0 || data[5] != 0 ||
+		(data[6] != 1 && data[6] != 2 && data[6] != 4) ||
+		(data[7] != 4 && data[7] != 8) {
+		return false
+	}
+	var order binary.ByteOrder
+	var words int // nfunc, nfiles, [textStart], funcname, cu, filetab, pctab, pcln
+	var functabField uint64
+	switch {
+	case binary.LittleEndian.Uint32(data) == go116magic:
+		order, words, functabField = binary.LittleEndian, 7, uint64(data[7])
+	case binary.BigEndian.Uint32(data) == go116magic:
+		order, words, functabField = binary.BigEndian, 7, uint64(data[7])
+	case binary.LittleEndian.Uint32(data) == go118magic, binary.LittleEndian.Uint32(data) == go120magic:
+		order, words, functabField = binary.LittleEndian, 8, 4
+	case binary.BigEndian.Uint32(data) == go118magic, binary.BigEndian.Uint32(data) == go120magic:
+		order, words, functabField = binary.BigEndian, 8, 4
+	default:
+		return false
+	}
+	ptrsize := int(data[7])
+	hdrSize := 8 + words*ptrsize
+	if len(data) < hdrSize {
+		return false
+	}
+	word := func(i int) uint64 {
+		if ptrsize == 4 {
+			return uint64(order.Uint32(data[8+i*4:]))
+		}
+		return order.Uint64(data[8+i*8:])
+	}
+	nfunc := word(0)
+	offsets := make([]uint64, 0, 5)
+	for i := words - 5; i < words; i++ {
+		offsets = append(offsets, word(i))
+	}
+	if nfunc == 0 || nfunc > uint64(len(data)) || offsets[0] < uint64(hdrSize) {
+		return false
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			return false
+		}
+	}
+	// functab: nfunc+1 (entry, funcoff) pairs
+	return offsets[4]+(nfunc*2+1)*functabField <= uint64(len(data))
+}
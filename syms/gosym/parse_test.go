@@ -0,0 +1,40 @@
+package gosym
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRuntimeTextFromPclntab18(t *testing.T) {
+	header := func(order binary.ByteOrder, magic uint32, ptrSize int, text uint64) []byte {
+		data := make([]byte, 64)
+		order.PutUint32(data, magic)
+		data[6], data[7] = 1, byte(ptrSize)
+		if ptrSize == 4 {
+			order.PutUint32(data[8+2*4:], uint32(text))
+		} else {
+			order.PutUint64(data[8+2*8:], text)
+		}
+		return data
+	}
+	testcases := []struct {
+		name string
+		data []byte
+		text uint64
+	}{
+		{"le64", header(binary.LittleEndian, go120magic, 8, 0x401000), 0x401000},
+		{"be64", header(binary.BigEndian, go120magic, 8, 0x11000), 0x11000},
+		{"le32", header(binary.LittleEndian, go118magic, 4, 0x8049000), 0x8049000},
+		{"be32", header(binary.BigEndian, go118magic, 4, 0x10000), 0x10000},
+		// go1.16 has no textStart
+		{"go116", header(binary.LittleEndian, go116magic, 8, 0x401000), 0},
+		{"short", make([]byte, 16), 0},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.text, ParseRuntimeTextFromPclntab18(tt.data))
+		})
+	}
+}
@@ -6,6 +6,7 @@
 // https://github.com/golang/go/blob/go1.20.5/src/debug/gosym/pclntab.go
 // modified go12Funcs function to be exported return a FlatFuncIndex instead of []Func
 // added FuncNameOffset to export the funcnametabOffset
+// ported pcvalue/pcfile/pcln decoding to the streaming PCLNData, see PCToFrames
 
 /*
  * Line tables
@@ -14,6 +15,7 @@
 package gosym
 
 import (
+	"bytes"
 	"encoding/binary"
 	"sync"
 )
@@ -43,6 +45,8 @@ const (
 //
 // For the most part, LineTable's methods should be treated as an internal
 // detail of the package; callers should use the methods on Table instead.
+//
+// Once parsed, a LineTable is safe for concurrent reads if its PCLNData is.
 type LineTable struct {
 	//Data []byte
 	PCLNData PCLNData
@@ -64,8 +68,21 @@ type LineTable struct {
 	functabOffset     uint64
 	nfunctab          uint32
 	funcnametabOffset uint64
+	nfiletab          uint32
+	cutabOffset       uint64
+	filetabOffset     uint64
+	pctabOffset       uint64
 	failed            bool
-	tmpbuf            [8]uint8
+
+	// FuncData reads funcdata living outside the pclntab, such as inline
+	// trees, addressed by virtual address. GoFunc is the address of the
+	// go:func.* symbol, the base of funcdata offsets since Go 1.18.
+	// Inlined frames are only expanded when FuncData is set.
+	FuncData PCLNData
+	GoFunc   uint64
+
+	// strings by offset
+	strings sync.Map
 }
 
 // NewLineTable returns a new PC/line table
@@ -117,7 +134,8 @@ const (
 // uintptr returns the pointer-sized value encoded at b.
 // The pointer size is dictated by the table being read.
 func (t *LineTable) uintptrAt(at int) uint64 {
-	tmpbuf := t.tmpbuf[:t.ptrsize]
+	var buf [8]byte
+	tmpbuf := buf[:t.ptrsize]
 	_ = t.PCLNData.ReadAt(tmpbuf, at)
 	if t.ptrsize == 4 {
 		return uint64(t.binary.Uint32(tmpbuf))
@@ -198,13 +216,21 @@ func (t *LineTable) parsePclnTab() {
 	switch possibleVersion {
 	case ver118, ver120:
 		t.nfunctab = uint32(offset(0))
+		t.nfiletab = uint32(offset(1))
 		t.textStart = t.PC // use the start PC instead of reading from the table, which may be unrelocated
 		t.funcnametabOffset = offset(3)
+		t.cutabOffset = offset(4)
+		t.filetabOffset = offset(5)
+		t.pctabOffset = offset(6)
 		t.funcdataOffset = offset(7)
 		t.functabOffset = offset(7)
 	case ver116:
 		t.nfunctab = uint32(offset(0))
+		t.nfiletab = uint32(offset(1))
 		t.funcnametabOffset = offset(2)
+		t.cutabOffset = offset(3)
+		t.filetabOffset = offset(4)
+		t.pctabOffset = offset(5)
 		t.funcdataOffset = offset(6)
 		t.functabOffset = offset(6)
 	case ver12:
@@ -212,6 +238,10 @@ func (t *LineTable) parsePclnTab() {
 		t.funcdataOffset = 0
 		t.funcnametabOffset = 0
 		t.functabOffset = uint64(8 + t.ptrsize)
+		t.pctabOffset = 0
+		functabsize := (int(t.nfunctab)*2 + 1) * t.functabFieldSize()
+		t.filetabOffset = uint64(t.uint32At(int(t.functabOffset) + functabsize))
+		t.nfiletab = t.uint32At(int(t.filetabOffset))
 	default:
 		panic("unreachable")
 	}
@@ -307,7 +337,8 @@ func (f funcTab) funcOff(i int) uint64 {
 
 // uint returns the uint stored at b.
 func (f funcTab) uintAt(at int) uint64 {
-	tmpbuf := f.tmpbuf[:f.sz]
+	var buf [8]byte
+	tmpbuf := buf[:f.sz]
 	_ = f.PCLNData.ReadAt(tmpbuf, at)
 	if f.sz == 4 {
 		return uint64(f.binary.Uint32(tmpbuf))
@@ -333,13 +364,17 @@ func (t *LineTable) funcData(i uint32) funcData {
 //	return f.t == nil && f.data == nil
 //}
 
-func (f funcData) nameOff() uint32 { return f.field(1) }
+func (f funcData) nameOff() uint32  { return f.field(1) }
+func (f funcData) pcfile() uint32   { return f.field(5) }
+func (f funcData) pcln() uint32     { return f.field(6) }
+func (f funcData) npcdata() uint32  { return f.field(7) }
+func (f funcData) cuOffset() uint32 { return f.field(8) }
 
 // field returns the nth field of the _func struct.
-// It panics if n == 0 or n > 9; for n == 0, call f.entryPC.
+// It panics if n == 0 or n > 10; for n == 0, call f.entryPC.
 // Most callers should use a named field accessor (just above).
 func (f funcData) field(n uint32) uint32 {
-	if n == 0 || n > 9 {
+	if n == 0 || n > 10 {
 		panic("bad funcdata field")
 	}
 	// In Go 1.18, the first field of _func changed
@@ -351,12 +386,133 @@ func (f funcData) field(n uint32) uint32 {
 	off := sz0 + (n-1)*4 // subsequent fields are 4 bytes each
 	dataOffset := f.dataOffset + f.t.funcdataOffset + uint64(off)
 	//data := f.data[off:]
-	data := f.t.tmpbuf[:4]
+	var buf [4]byte
+	data := buf[:]
 
 	_ = f.t.PCLNData.ReadAt(data, int(dataOffset))
 	return f.t.binary.Uint32(data)
 }
 
+// uint32At returns the uint32 stored at offset at.
+func (t *LineTable) uint32At(at int) uint32 {
+	var buf [4]byte
+	tmpbuf := buf[:]
+	if err := t.PCLNData.ReadAt(tmpbuf, at); err != nil {
+		panic(err)
+	}
+	return t.binary.Uint32(tmpbuf)
+}
+
+// readvarint reads a varint at *p and advances *p past it.
+func (t *LineTable) readvarint(p *int) uint32 {
+	var v, shift uint32
+	var buf [1]byte
+	b := buf[:]
+	for shift = 0; shift < 35; shift += 7 {
+		if err := t.PCLNData.ReadAt(b, *p); err != nil {
+			panic(err)
+		}
+		*p++
+		v |= (uint32(b[0]) & 0x7F) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+// stringAt returns the NUL terminated string found at offset at.
+func (t *LineTable) stringAt(at int) string {
+	if s, ok := t.strings.Load(at); ok {
+		return s.(string)
+	}
+	var buf []byte
+	for off := at; len(buf) < 4096; off += 64 {
+		// the string may end close to the end of the data, read byte by
+		// byte once a whole chunk is not available
+		var chunk [64]byte
+		n := len(chunk)
+		if err := t.PCLNData.ReadAt(chunk[:], off); err != nil {
+			n = 0
+			for ; n < len(chunk); n++ {
+				if t.PCLNData.ReadAt(chunk[n:n+1], off+n) != nil {
+					break
+				}
+			}
+		}
+		if i := bytes.IndexByte(chunk[:n], 0); i >= 0 {
+			buf = append(buf, chunk[:i]...)
+			break
+		}
+		if n < len(chunk) {
+			return ""
+		}
+		buf = append(buf, chunk[:]...)
+	}
+	s := string(buf)
+	t.strings.Store(at, s)
+	return s
+}
+
+// step advances to the next pc, value pair in the encoded table.
+func (t *LineTable) step(p *int, pc *uint64, val *int32, first bool) bool {
+	uvdelta := t.readvarint(p)
+	if uvdelta == 0 && !first {
+		return false
+	}
+	if uvdelta&1 != 0 {
+		uvdelta = ^(uvdelta >> 1)
+	} else {
+		uvdelta >>= 1
+	}
+	vdelta := int32(uvdelta)
+	pcdelta := t.readvarint(p) * t.quantum
+	*pc += uint64(pcdelta)
+	*val += vdelta
+	return true
+}
+
+// pcvalue reports the value associated with the target pc.
+// off is the offset to the beginning of the pc-value table,
+// and entry is the start PC for the corresponding function.
+func (t *LineTable) pcvalue(off uint32, entry, targetpc uint64) int32 {
+	p := int(t.pctabOffset) + int(off)
+
+	val := int32(-1)
+	pc := entry
+	for t.step(&p, &pc, &val, pc == entry) {
+		if targetpc < pc {
+			return val
+		}
+	}
+	return -1
+}
+
+// pcfile maps program counter to file name for the Go 1.2+ pcln table.
+func (t *LineTable) pcfile(f funcData, entry, pc uint64) string {
+	fno := t.pcvalue(f.pcfile(), entry, pc)
+	if t.version == ver12 {
+		if fno <= 0 {
+			return ""
+		}
+		return t.stringAt(int(t.uint32At(int(t.filetabOffset) + 4*int(fno))))
+	}
+	// Go ≥ 1.16
+	if fno < 0 { // 0 is valid for ≥ 1.16
+		return ""
+	}
+	cuoff := f.cuOffset()
+	if fnoff := t.uint32At(int(t.cutabOffset) + int(cuoff+uint32(fno))*4); fnoff != ^uint32(0) {
+		return t.stringAt(int(t.filetabOffset) + int(fnoff))
+	}
+	return ""
+}
+
+// funcName returns the function name found at off of the funcnametab.
+func (t *LineTable) funcName(off uint32) string {
+	return t.stringAt(int(t.funcnametabOffset) + int(off))
+}
+
 func (t *LineTable) IsFailed() bool {
 	return t.failed
 }
@@ -0,0 +1,25 @@
+// Package testcgo loads native code for the tests of syms. It is only
+// imported by tests, so that the library does not link libdl.
+package testcgo
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+static size_t get_cxx_addr__(const char *name) {
+	void *lib = dlopen("libstdc++.so.6", RTLD_NOW);
+	if (!lib) return 0;
+	return (size_t)dlsym(lib, name);
+}
+*/
+import "C"
+import "unsafe"
+
+// CxxAddr loads libstdc++ and returns the address of its function name, 0
+// if the library or the function is not found.
+func CxxAddr(name string) uint64 {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return uint64(C.get_cxx_addr__(cname))
+}
@@ -0,0 +1,345 @@
+package syms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/vietanhduong/profiling/syms/elf"
+)
+
+// jitdump format, see tools/perf/Documentation/jitdump-specification.txt
+// in the Linux kernel sources.
+const (
+	jitDumpMagic         = 0x4A695444 // "JiTD"
+	jitDumpHeaderSize    = 40
+	jitRecordHeaderSize  = 16
+	jitCodeLoad          = 0
+	jitCodeMove          = 1
+	jitCodeDebugInfo     = 2
+	jitCodeClose         = 3
+	jitCodeUnwindingInfo = 4
+)
+
+var errJitDumpMagic = errors.New("not a jitdump file")
+
+// jitDumpReloadInterval limits how often a miss triggers a check of the
+// dump for new records.
+var jitDumpReloadInterval = time.Second
+
+// JitDumpTable resolves JIT-compiled code from a jit-<pid>.dump file written
+// by JIT runtimes (JVM agents, V8, .NET). Code load, code move and debug
+// info records are applied in file order; records appended after the first
+// load are read when an address is not found. Lookups share mu, reloads
+// hold it exclusively.
+type JitDumpTable struct {
+	mu         sync.RWMutex
+	path       string
+	order      binary.ByteOrder
+	offset     int64 // bytes of the file already parsed
+	codes      map[uint64]*jitCode
+	sorted     []*jitCode // sorted by start
+	debug      map[uint64][]jitLine
+	lastReload time.Time
+	// err is set when the dump cannot be read, invalid records are only
+	// skipped until the runtime rewrites them
+	err error
+}
+
+type jitCode struct {
+	start uint64
+	size  uint64
+	name  string
+	lines []jitLine // sorted by addr
+}
+
+type jitLine struct {
+	addr uint64
+	file string
+	line int
+}
+
+func NewJitDumpTable(path string) *JitDumpTable {
+	t := &JitDumpTable{
+		path:  path,
+		codes: make(map[uint64]*jitCode),
+		debug: make(map[uint64][]jitLine),
+	}
+	if err := t.reload(); err != nil {
+		glog.Warningf("Failed to load jitdump %s: %v", path, err)
+	}
+	return t
+}
+
+func (t *JitDumpTable) Resolve(addr uint64) string {
+	sym, _ := t.find(addr)
+	return sym.name
+}
+
+func (t *JitDumpTable) ResolveStart(addr uint64) (string, uint64) {
+	sym, _ := t.find(addr)
+	return sym.name, sym.start
+}
+
+func (t *JitDumpTable) ResolveLine(addr uint64) (string, int) {
+	sym, _ := t.find(addr)
+	return sym.file, sym.line
+}
+
+func (t *JitDumpTable) ResolveFrames(addr uint64) []elf.Frame {
+	sym, ok := t.find(addr)
+	if !ok {
+		return nil
+	}
+	return []elf.Frame{{Name: sym.name, File: sym.file, Line: sym.line}}
+}
+
+// jitSymbol is the code at an address and its source position, copied out
+// of the table.
+type jitSymbol struct {
+	start uint64
+	name  string
+	file  string
+	line  int
+}
+
+// find returns the code at addr, reloading the dump on a miss.
+func (t *JitDumpTable) find(addr uint64) (jitSymbol, bool) {
+	t.mu.RLock()
+	sym, ok := t.lookup(addr)
+	reload := !ok && time.Since(t.lastReload) >= jitDumpReloadInterval
+	t.mu.RUnlock()
+	if !reload {
+		return sym, ok
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// another caller may have reloaded meanwhile
+	if time.Since(t.lastReload) >= jitDumpReloadInterval {
+		if err := t.reload(); err != nil {
+			glog.V(5).Infof("Failed to reload jitdump %s: %v", t.path, err)
+			return jitSymbol{}, false
+		}
+	}
+	return t.lookup(addr)
+}
+
+// lookup returns the code at addr, t.mu must be held. Moved code is updated
+// in place.
+func (t *JitDumpTable) lookup(addr uint64) (jitSymbol, bool) {
+	i := sort.Search(len(t.sorted), func(i int) bool { return addr < t.sorted[i].start })
+	if i == 0 {
+		return jitSymbol{}, false
+	}
+	c := t.sorted[i-1]
+	if addr >= c.start+c.size {
+		return jitSymbol{}, false
+	}
+	sym := jitSymbol{start: c.start, name: c.name}
+	j := sort.Search(len(c.lines), func(j int) bool { return addr < c.lines[j].addr })
+	if j > 0 {
+		sym.file, sym.line = c.lines[j-1].file, c.lines[j-1].line
+	}
+	return sym, true
+}
+
+// reload applies the records appended since the last load.
+func (t *JitDumpTable) reload() error {
+	t.lastReload = time.Now()
+	f, err := os.Open(t.path)
+	if err != nil {
+		t.err = err
+		return err
+	}
+	defer f.Close()
+	t.err = nil
+
+	info, err := f.Stat()
+	if err != nil {
+		t.err = err
+		return err
+	}
+	if info.Size() < t.offset {
+		// the file was truncated, e.g. the process restarted with the same pid
+		glog.V(5).Infof("Jitdump %s truncated, reloading", t.path)
+		t.reset()
+	}
+	if info.Size() == t.offset {
+		return nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, t.offset, info.Size()-t.offset))
+	if err != nil {
+		t.err = err
+		return err
+	}
+
+	if t.offset == 0 {
+		if len(data) < jitDumpHeaderSize {
+			// the runtime has not finished writing the header yet
+			return nil
+		}
+		n, err := t.parseHeader(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		t.offset += int64(n)
+	}
+
+	changed := false
+	// the records preceding an invalid one are still applied
+	var invalid error
+	// only consume complete records, the runtime may be in the middle of a write
+	for len(data) >= jitRecordHeaderSize {
+		id := t.order.Uint32(data[0:])
+		size := int(t.order.Uint32(data[4:]))
+		if size < jitRecordHeaderSize {
+			invalid = fmt.Errorf("jitdump record at %d has invalid size %d", t.offset, size)
+			break
+		}
+		if size > len(data) {
+			break
+		}
+		if id == jitCodeClose {
+			// nothing is written after a close record
+			t.offset += int64(size)
+			break
+		}
+		changed = t.applyRecord(id, data[jitRecordHeaderSize:size]) || changed
+		data = data[size:]
+		t.offset += int64(size)
+	}
+	if changed {
+		t.sorted = t.sorted[:0]
+		for _, c := range t.codes {
+			t.sorted = append(t.sorted, c)
+		}
+		sort.Slice(t.sorted, func(i, j int) bool { return t.sorted[i].start < t.sorted[j].start })
+	}
+	return invalid
+}
+
+// parseHeader validates the file header and detects the byte order of the
+// writer. It returns the size of the header.
+func (t *JitDumpTable) parseHeader(data []byte) (int, error) {
+	switch {
+	case binary.LittleEndian.Uint32(data) == jitDumpMagic:
+		t.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == jitDumpMagic:
+		t.order = binary.BigEndian
+	default:
+		return 0, errJitDumpMagic
+	}
+	size := int(t.order.Uint32(data[8:]))
+	if size < jitDumpHeaderSize || size > len(data) {
+		return 0, fmt.Errorf("jitdump invalid header size %d", size)
+	}
+	return size, nil
+}
+
+// applyRecord applies a record body, it reports whether the set of code
+// regions changed.
+func (t *JitDumpTable) applyRecord(id uint32, body []byte) bool {
+	switch id {
+	case jitCodeLoad:
+		// pid u32, tid u32, vma u64, code_addr u64, code_size u64,
+		// code_index u64, name, native code
+		if len(body) < 40 {
+			return false
+		}
+		c := &jitCode{
+			start: t.order.Uint64(body[16:]),
+			size:  t.order.Uint64(body[24:]),
+			name:  cstring(body[40:]),
+		}
+		if lines, ok := t.debug[c.start]; ok {
+			c.lines = lines
+			delete(t.debug, c.start)
+		}
+		t.codes[c.start] = c
+		return true
+	case jitCodeMove:
+		// pid u32, tid u32, vma u64, old_code_addr u64, new_code_addr u64,
+		// code_size u64, code_index u64
+		if len(body) < 48 {
+			return false
+		}
+		from, to := t.order.Uint64(body[16:]), t.order.Uint64(body[24:])
+		c, ok := t.codes[from]
+		if !ok {
+			return false
+		}
+		delete(t.codes, from)
+		for i := range c.lines {
+			c.lines[i].addr = c.lines[i].addr - from + to
+		}
+		c.start = to
+		c.size = t.order.Uint64(body[32:])
+		t.codes[to] = c
+		return true
+	case jitCodeDebugInfo:
+		// code_addr u64, nr_entry u64, entries of addr u64, line u32,
+		// discrim u32, filename
+		if len(body) < 16 {
+			return false
+		}
+		addr, n := t.order.Uint64(body), t.order.Uint64(body[8:])
+		body = body[16:]
+		lines := make([]jitLine, 0, min(n, uint64(len(body)/16)))
+		var file string
+		for i := uint64(0); i < n && len(body) > 16; i++ {
+			l := jitLine{addr: t.order.Uint64(body), line: int(t.order.Uint32(body[8:]))}
+			name := body[16:]
+			end := bytes.IndexByte(name, 0)
+			if end < 0 {
+				break
+			}
+			// "\xff\0" repeats the previous file name
+			if !(end == 1 && name[0] == 0xff) {
+				file = string(name[:end])
+			}
+			l.file = file
+			lines = append(lines, l)
+			body = name[end+1:]
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].addr < lines[j].addr })
+		// debug info precedes the code load record it describes
+		t.debug[addr] = lines
+		return false
+	}
+	return false
+}
+
+func (t *JitDumpTable) Cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset()
+}
+
+func (t *JitDumpTable) reset() {
+	clear(t.codes)
+	clear(t.debug)
+	t.sorted = nil
+	t.offset = 0
+}
+
+// IsDead reports whether the dump vanished or cannot be read.
+func (t *JitDumpTable) IsDead() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.err != nil
+}
+
+func (t *JitDumpTable) Size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.sorted)
+}
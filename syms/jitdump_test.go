@@ -0,0 +1,185 @@
+package syms
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/proc"
+)
+
+func TestJitDumpTable_Resolve(t *testing.T) {
+	// same records, written by a little and a big endian runtime
+	for _, path := range []string{"testdata/jit-1234.dump", "testdata/jit-1235.dump"} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			tbl := NewJitDumpTable(path)
+			assert.False(t, tbl.IsDead())
+			assert.Equal(t, 3, tbl.Size())
+
+			testcases := []struct {
+				addr uint64
+				name string
+				file string
+				line int
+			}{
+				{0x7f0000001000, "LMain;::run", "Main.java", 10},
+				{0x7f0000001018, "LMain;::run", "Main.java", 12},
+				{0x7f000000103f, "LMain;::run", "Util.java", 20},
+				{0x7f0000001040, "", "", 0},
+				// moved to 0x7f0000003000
+				{0x7f0000002010, "", "", 0},
+				{0x7f0000003010, "LMain;::helper", "", 0},
+				{0x7f0000004000, "LMain;::late", "", 0},
+			}
+			for _, tt := range testcases {
+				assert.Equal(t, tt.name, tbl.Resolve(tt.addr), "addr 0x%x", tt.addr)
+				file, line := tbl.ResolveLine(tt.addr)
+				assert.Equal(t, tt.file, file, "addr 0x%x", tt.addr)
+				assert.Equal(t, tt.line, line, "addr 0x%x", tt.addr)
+			}
+		})
+	}
+}
+
+func TestJitDumpTable_Incremental(t *testing.T) {
+	interval := jitDumpReloadInterval
+	jitDumpReloadInterval = 0
+	t.Cleanup(func() { jitDumpReloadInterval = interval })
+
+	data, err := os.ReadFile("testdata/jit-1234.dump")
+	require.NoError(t, err)
+	// end of the second code load record, plus half of the next record
+	end := jitRecordEnd(data, 3)
+	path := filepath.Join(t.TempDir(), "jit-1234.dump")
+	require.NoError(t, os.WriteFile(path, data[:end+10], 0o644))
+
+	tbl := NewJitDumpTable(path)
+	assert.Equal(t, 2, tbl.Size())
+	assert.Equal(t, "LMain;::helper", tbl.Resolve(0x7f0000002010))
+	assert.Equal(t, "", tbl.Resolve(0x7f0000004000))
+
+	// the runtime appends the remaining records
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.Equal(t, "LMain;::late", tbl.Resolve(0x7f0000004000))
+	assert.Equal(t, "LMain;::helper", tbl.Resolve(0x7f0000003010))
+	assert.Equal(t, "", tbl.Resolve(0x7f0000002010))
+	assert.Equal(t, 3, tbl.Size())
+	// moved code starts at its new address
+	name, start := tbl.ResolveStart(0x7f0000003010)
+	assert.Equal(t, "LMain;::helper", name)
+	assert.Equal(t, uint64(0x7f0000003000), start)
+
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, "", tbl.Resolve(0x2000))
+	assert.True(t, tbl.IsDead())
+}
+
+func TestJitDumpTable_Concurrent(t *testing.T) {
+	interval := jitDumpReloadInterval
+	jitDumpReloadInterval = 0
+	t.Cleanup(func() { jitDumpReloadInterval = interval })
+
+	data, err := os.ReadFile("testdata/jit-1234.dump")
+	require.NoError(t, err)
+	end := jitRecordEnd(data, 3)
+	path := filepath.Join(t.TempDir(), "jit-1234.dump")
+	require.NoError(t, os.WriteFile(path, data[:end], 0o644))
+	tbl := NewJitDumpTable(path)
+
+	// misses reload the dump while the runtime moves and adds code
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Contains(t, []string{"", "LMain;::late"}, tbl.Resolve(0x7f0000004000))
+				assert.Contains(t, []string{"", "LMain;::helper"}, tbl.Resolve(0x7f0000003010))
+				tbl.ResolveFrames(0x7f0000002010)
+			}
+		}()
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	wg.Wait()
+	assert.Equal(t, "LMain;::late", tbl.Resolve(0x7f0000004000))
+	assert.Equal(t, "LMain;::helper", tbl.Resolve(0x7f0000003010))
+}
+
+func TestJitDumpTable_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jit-1.dump")
+	require.NoError(t, os.WriteFile(path, make([]byte, 64), 0o644))
+	tbl := NewJitDumpTable(path)
+	// only a dump which cannot be read is dead
+	assert.False(t, tbl.IsDead())
+	assert.Equal(t, "", tbl.Resolve(0x1000))
+}
+
+func TestJitDumpTable_TruncatedRecord(t *testing.T) {
+	interval := jitDumpReloadInterval
+	jitDumpReloadInterval = 0
+	t.Cleanup(func() { jitDumpReloadInterval = interval })
+
+	data, err := os.ReadFile("testdata/jit-1234.dump")
+	require.NoError(t, err)
+	end := jitRecordEnd(data, 3)
+	// the runtime has written the size of the next record but not the
+	// record itself
+	partial := append([]byte{}, data[:end]...)
+	partial = binary.LittleEndian.AppendUint32(partial, jitCodeLoad)
+	partial = binary.LittleEndian.AppendUint32(partial, 8)
+	partial = append(partial, make([]byte, 8)...)
+	path := filepath.Join(t.TempDir(), "jit-1234.dump")
+	require.NoError(t, os.WriteFile(path, partial, 0o644))
+
+	tbl := NewJitDumpTable(path)
+	assert.False(t, tbl.IsDead())
+	assert.Equal(t, "LMain;::helper", tbl.Resolve(0x7f0000002010))
+	assert.Equal(t, "", tbl.Resolve(0x7f0000004000))
+	assert.False(t, tbl.IsDead())
+
+	// the record is read once complete
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.Equal(t, "LMain;::late", tbl.Resolve(0x7f0000004000))
+}
+
+func TestProcModule_RetryDeadTable(t *testing.T) {
+	jitInterval, retryInterval := jitDumpReloadInterval, moduleRetryInterval
+	jitDumpReloadInterval, moduleRetryInterval = 0, time.Hour
+	t.Cleanup(func() { jitDumpReloadInterval, moduleRetryInterval = jitInterval, retryInterval })
+
+	path := filepath.Join(t.TempDir(), "jit-1234.dump")
+	data, err := os.ReadFile("testdata/jit-1234.dump")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	m := NewProcModule(path, &proc.Map{}, newProcPath(path, 0, -1, true), nil)
+	defer m.Cleanup()
+	require.Equal(t, "LMain;::late", m.Resolve(0x7f0000004000))
+	tbl := m.table
+
+	// the misses of a vanished dump do not reload the table every time
+	require.NoError(t, os.Remove(path))
+	for i := 0; i < 3; i++ {
+		require.Empty(t, m.Resolve(0x1000))
+	}
+	require.True(t, tbl.IsDead())
+	assert.Same(t, tbl, m.table)
+
+	moduleRetryInterval = 0
+	require.Empty(t, m.Resolve(0x1000))
+	assert.NotSame(t, tbl, m.table)
+}
+
+// jitRecordEnd returns the offset following the first n records of a little
+// endian dump.
+func jitRecordEnd(data []byte, n int) int {
+	off := int(binary.LittleEndian.Uint32(data[8:]))
+	for i := 0; i < n; i++ {
+		off += int(binary.LittleEndian.Uint32(data[off+4:]))
+	}
+	return off
+}
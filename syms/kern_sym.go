@@ -3,12 +3,15 @@ package syms
 import (
 	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/vietanhduong/profiling/proc"
 )
 
+// KernSym is safe for concurrent use, lookups share mu.
 type KernSym struct {
+	mu      sync.RWMutex
 	path    string
 	symbols []Symbol
 	base    uint64
@@ -24,6 +27,8 @@ func NewKernSym() (*KernSym, error) {
 }
 
 func (s *KernSym) Refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if len(s.symbols) != 0 {
 		return
 	}
@@ -35,21 +40,65 @@ func (s *KernSym) Refresh() {
 	s.symbols = symbols
 }
 
-func (s *KernSym) Rebase(base uint64) { s.base = base }
+func (s *KernSym) Rebase(base uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base = base
+}
 
-func (s *KernSym) Cleanup() { s.symbols = s.symbols[:0] }
+func (s *KernSym) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.symbols = s.symbols[:0]
+}
 
 func (s *KernSym) Resolve(addr uint64) Symbol {
-	s.Refresh()
-	var empty Symbol
-	if len(s.symbols) == 0 {
-		return empty
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolve(addr)
+}
+
+func (s *KernSym) ResolveFrames(addr uint64) []Symbol { return []Symbol{s.Resolve(addr)} }
+
+func (s *KernSym) ResolveStack(addrs []uint64) []Symbol {
+	if len(addrs) == 0 {
+		return nil
+	}
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Symbol, len(addrs))
+	for i, addr := range addrs {
+		res[i] = s.resolve(addr)
+	}
+	return res
+}
+
+// load parses the symbols if they are not loaded yet.
+func (s *KernSym) load() {
+	s.mu.RLock()
+	loaded := len(s.symbols) != 0
+	s.mu.RUnlock()
+	if !loaded {
+		s.Refresh()
+	}
+}
+
+// resolve returns the symbol of addr, s.mu must be held.
+func (s *KernSym) resolve(addr uint64) Symbol {
+	unknown := Symbol{Addr: addr, UnknownModule: true}
+	if len(s.symbols) == 0 || addr < s.base {
+		return unknown
 	}
-	addr -= s.base
-	if addr < s.symbols[0].Start {
-		return empty
+	rel := addr - s.base
+	if rel < s.symbols[0].Start {
+		return unknown
 	}
-	i := sort.Search(len(s.symbols), func(i int) bool { return addr < s.symbols[i].Start })
+	i := sort.Search(len(s.symbols), func(i int) bool { return rel < s.symbols[i].Start })
 	i--
-	return s.symbols[i]
+	sym := s.symbols[i]
+	sym.Addr = addr
+	sym.setFuncStart(sym.Start + s.base)
+	return sym
 }
@@ -1,6 +1,7 @@
 package syms
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +29,50 @@ func TestKernSym_Resolve(t *testing.T) {
 		assert.Equal(t, tt.mod, sym.Module)
 	}
 }
+
+func TestKernSym_Concurrent(t *testing.T) {
+	// the symbols are loaded by the first readers
+	resolver := &KernSym{path: "./testdata/kallsyms"}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sym := resolver.Resolve(0xffffffffc035f2e0)
+				assert.Equal(t, "autofs_dev_ioctl_ismountpoint", sym.Name)
+				resolver.Refresh()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestKernSym_ResolveStack(t *testing.T) {
+	resolver := &KernSym{path: "./testdata/kallsyms"}
+	stack := []uint64{0xffffffffc035f2e0, 0x0000000000000000, 0xffffffffb5000075, 0xffffffffc035f2e0}
+	res := resolver.ResolveStack(stack)
+	require.Len(t, res, len(stack))
+	for i, addr := range stack {
+		assert.Equal(t, resolver.Resolve(addr), res[i])
+	}
+}
+
+func TestKernSym_Symbol(t *testing.T) {
+	resolver := &KernSym{path: "./testdata/kallsyms"}
+	sym := resolver.Resolve(0xffffffffc035f2e8)
+	assert.Equal(t, "autofs_dev_ioctl_ismountpoint", sym.Name)
+	assert.Equal(t, uint64(0xffffffffc035f2e8), sym.Addr)
+	assert.Equal(t, uint64(0xffffffffc035f2e0), sym.FuncStart)
+	assert.Equal(t, uint64(8), sym.FuncOffset)
+	assert.False(t, sym.UnknownModule)
+
+	// symbols move with the base, the address does not
+	resolver.Rebase(0x1000)
+	sym = resolver.Resolve(0xffffffffc035f2e8 + 0x1000)
+	assert.Equal(t, "autofs_dev_ioctl_ismountpoint", sym.Name)
+	assert.Equal(t, uint64(0xffffffffc035f2e0+0x1000), sym.FuncStart)
+	assert.Equal(t, uint64(8), sym.FuncOffset)
+
+	assert.Equal(t, Symbol{Addr: 0x10, UnknownModule: true}, resolver.Resolve(0x10))
+}
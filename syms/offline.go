@@ -0,0 +1,314 @@
+package syms
+
+import (
+	"context"
+	delf "debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+)
+
+// OfflineResolver resolves the symbols reported in deferred mode (see
+// SymbolOptions.Deferred), as the build ID of a module and a file offset,
+// away from the profiled processes.
+//
+// The binaries are searched in a directory indexed by build ID like
+// /usr/lib/debug: .build-id/ab/cdef is the binary of the build ID abcdef and
+// .build-id/ab/cdef.debug its debug file. Other binaries, such as Go
+// binaries without GNU build ID, are found by reading the build ID of every
+// file of the directory. Binaries which are not in the directory are
+// downloaded from SymbolOptions.Debuginfod. The binaries are required to
+// translate file offsets, their debug files are optional.
+//
+// OfflineResolver is safe for concurrent use. Binaries are looked up and
+// downloaded without holding mu, once per build ID.
+type OfflineResolver struct {
+	dir  string
+	opts *SymbolOptions
+
+	mu sync.Mutex
+	// modules by build ID, nil when the binary is not found
+	modules map[elf.BuildId]*offlineModule
+	// modules being opened by build ID, closed once in modules
+	opening map[elf.BuildId]chan struct{}
+
+	indexMu sync.Mutex
+	// binaries of the directory by build ID, read on the first miss
+	index map[elf.BuildId]string
+}
+
+type offlineModule struct {
+	module *ProcModule
+	progs  []delf.ProgHeader
+}
+
+// NewOfflineResolver returns a resolver of the binaries and debug files of
+// dir. The debug files of dir are searched before SymbolOptions.HostDebugDirs.
+func NewOfflineResolver(dir string, opts *SymbolOptions) (*OfflineResolver, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("offline symbols dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("offline symbols dir %s is not a directory", dir)
+	}
+	if opts == nil {
+		opts = defaultSymbolOpts
+	}
+	o := *opts
+	o.Deferred = false
+	o.UseDebugFile = true
+	o.HostDebugDirs = append([]string{dir}, opts.HostDebugDirs...)
+	return &OfflineResolver{
+		dir:     dir,
+		opts:    &o,
+		modules: make(map[elf.BuildId]*offlineModule),
+		opening: make(map[elf.BuildId]chan struct{}),
+	}, nil
+}
+
+// Resolve returns the symbol at offset in the module with build ID id. The
+// module is the path of its binary, the addresses are its link addresses.
+func (r *OfflineResolver) Resolve(id elf.BuildId, offset uint64) Symbol {
+	frames := r.ResolveFrames(id, offset)
+	return frames[len(frames)-1]
+}
+
+// ResolveFrames returns the logical frames at offset in the module with
+// build ID id, innermost first, see Resolve.
+func (r *OfflineResolver) ResolveFrames(id elf.BuildId, offset uint64) []Symbol {
+	sym := Symbol{BuildId: id, FileOffset: offset}
+	m := r.module(id)
+	if m == nil {
+		return []Symbol{sym}
+	}
+	addr, ok := m.addr(offset)
+	if !ok {
+		return []Symbol{sym}
+	}
+	sym.Start, sym.Addr, sym.Module = addr, addr, m.module.name
+	name, start := m.module.ResolveStart(addr)
+	if name == "" {
+		return []Symbol{sym}
+	}
+	sym.Name = name
+	sym.setFuncStart(start)
+	sym.File, sym.Line = m.module.ResolveLine(addr)
+	return moduleFrames(sym, m.module, addr)
+}
+
+// Symbolize resolves a stack reported in deferred mode, innermost first. The
+// symbols keep the module, addresses and mapping of the profiled process.
+// Symbols without build ID or which are already named are kept as is.
+func (r *OfflineResolver) Symbolize(stack []Symbol) []Symbol {
+	type key struct {
+		id     elf.BuildId
+		offset uint64
+	}
+	resolved := make(map[key][]Symbol)
+	res := make([]Symbol, 0, len(stack))
+	for _, sym := range stack {
+		if sym.Name != "" || sym.BuildId.Empty() {
+			res = append(res, sym)
+			continue
+		}
+		k := key{sym.BuildId, sym.FileOffset}
+		frames, ok := resolved[k]
+		if !ok {
+			frames = r.ResolveFrames(sym.BuildId, sym.FileOffset)
+			resolved[k] = frames
+		}
+		for _, f := range frames {
+			s := sym
+			s.Name, s.File, s.Line = f.Name, f.File, f.Line
+			if f.FuncStart != 0 {
+				s.FuncStart, s.FuncOffset = sym.Addr-f.FuncOffset, f.FuncOffset
+			}
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Refresh forgets the binaries which were not found, so that the files added
+// to the directory since are used.
+func (r *OfflineResolver) Refresh() {
+	r.mu.Lock()
+	for id, m := range r.modules {
+		if m == nil {
+			delete(r.modules, id)
+		}
+	}
+	r.mu.Unlock()
+	r.indexMu.Lock()
+	r.index = nil
+	r.indexMu.Unlock()
+}
+
+// Cleanup releases the modules, it must not be called concurrently with
+// resolution.
+func (r *OfflineResolver) Cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.modules {
+		if m != nil {
+			m.module.Cleanup()
+		}
+	}
+	clear(r.modules)
+}
+
+func (r *OfflineResolver) module(id elf.BuildId) *offlineModule {
+	r.mu.Lock()
+	for {
+		if m, ok := r.modules[id]; ok {
+			r.mu.Unlock()
+			return m
+		}
+		opening, ok := r.opening[id]
+		if !ok {
+			break
+		}
+		r.mu.Unlock()
+		<-opening
+		r.mu.Lock()
+	}
+	opening := make(chan struct{})
+	r.opening[id] = opening
+	r.mu.Unlock()
+
+	m := r.open(id)
+	r.mu.Lock()
+	r.modules[id] = m
+	delete(r.opening, id)
+	r.mu.Unlock()
+	close(opening)
+	return m
+}
+
+// open returns the module of the binary of id.
+func (r *OfflineResolver) open(id elf.BuildId) *offlineModule {
+	path := r.findBinary(id)
+	if path == "" {
+		glog.V(5).Infof("No binary found (build_id=%s)", id.Id)
+		return nil
+	}
+	mf, err := elf.NewMMapedElfFile(path)
+	if err != nil {
+		glog.Errorf("Failed to open mmaped file %s: %v", path, err)
+		return nil
+	}
+	defer mf.Close()
+
+	// The module is mapped at its link addresses, the addresses translated
+	// from file offsets are the ones of its tables.
+	procmap := &proc.Map{Pathname: path}
+	for _, p := range mf.Progs {
+		if p.Type == delf.PT_LOAD && p.Flags&delf.PF_X != 0 {
+			pageMask := uint64(os.Getpagesize() - 1)
+			procmap.StartAddr = p.Vaddr &^ pageMask
+			procmap.EndAddr = p.Vaddr + p.Memsz
+			procmap.FileOffset = uint(p.Off &^ pageMask)
+			break
+		}
+	}
+	return &offlineModule{
+		module: NewProcModule(path, procmap, newProcPath(path, 0, -1, true), r.opts),
+		progs:  mf.Progs,
+	}
+}
+
+// findBinary returns the path of the binary of id.
+func (r *OfflineResolver) findBinary(id elf.BuildId) string {
+	if id.GNU() && len(id.Id) > 2 && isHex(id.Id) {
+		path := filepath.Join(r.dir, ".build-id", id.Id[:2], id.Id[2:])
+		if got, ok := binaryBuildId(path); ok && got == id {
+			return path
+		}
+	}
+	if path := r.binaries()[id]; path != "" {
+		return path
+	}
+	if r.opts.Debuginfod == nil || !id.GNU() {
+		return ""
+	}
+	path, err := r.opts.Debuginfod.Executable(context.Background(), id)
+	if err != nil {
+		if !errors.Is(err, ErrDebuginfodNotFound) {
+			glog.Warningf("Failed to fetch binary (build_id=%s): %v", id.Id, err)
+		}
+		return ""
+	}
+	return path
+}
+
+// binaries returns the binaries of the directory by build ID, indexed on
+// first use.
+func (r *OfflineResolver) binaries() map[elf.BuildId]string {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	if r.index == nil {
+		r.index = indexBinaries(r.dir)
+	}
+	return r.index
+}
+
+// addr translates a file offset to the address it is loaded at.
+func (m *offlineModule) addr(offset uint64) (uint64, bool) {
+	for _, p := range m.progs {
+		if p.Type == delf.PT_LOAD && offset >= p.Off && offset < p.Off+p.Filesz {
+			return p.Vaddr + offset - p.Off, true
+		}
+	}
+	return 0, false
+}
+
+// indexBinaries returns the binaries of dir by build ID.
+func indexBinaries(dir string) map[elf.BuildId]string {
+	index := make(map[elf.BuildId]string)
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if id, ok := binaryBuildId(path); ok {
+			if _, dup := index[id]; !dup {
+				index[id] = path
+			}
+		}
+		return nil
+	})
+	glog.V(5).Infof("Indexed %d binaries of %s", len(index), dir)
+	return index
+}
+
+// binaryBuildId returns the build ID of the ELF file at path, it reports
+// false for debug files, whose code is not in the file.
+func binaryBuildId(path string) (elf.BuildId, bool) {
+	mf, err := elf.NewMMapedElfFile(path)
+	if err != nil {
+		return elf.BuildId{}, false
+	}
+	defer mf.Close()
+	code := false
+	for _, p := range mf.Progs {
+		if p.Type == delf.PT_LOAD && p.Flags&delf.PF_X != 0 && p.Filesz > 0 {
+			code = true
+			break
+		}
+	}
+	if !code {
+		return elf.BuildId{}, false
+	}
+	id, err := mf.BuildId()
+	if err != nil {
+		return elf.BuildId{}, false
+	}
+	return id, true
+}
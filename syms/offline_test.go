@@ -0,0 +1,177 @@
+package syms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/syms/elf"
+	"golang.org/x/sys/unix"
+)
+
+func copyTestFile(t *testing.T, src, dst string) {
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+	require.NoError(t, os.WriteFile(dst, data, 0o644))
+}
+
+func TestOfflineResolver(t *testing.T) {
+	dir := t.TempDir()
+	buildIdPath := filepath.Join(dir, ".build-id", "1f", "cfa068c5fdb9f31e6d9f3f89019beacb70182d")
+	copyTestFile(t, "elf/testdata/elfs/elf.stripped", buildIdPath)
+	copyTestFile(t, "elf/testdata/elfs/elf.debug", buildIdPath+".debug")
+	// found by its build ID
+	copyTestFile(t, "elf/testdata/elfs/go20", filepath.Join(dir, "bin", "go20"))
+	mf, err := elf.NewMMapedElfFile("elf/testdata/elfs/go20")
+	require.NoError(t, err)
+	goId, err := mf.BuildId()
+	mf.Close()
+	require.NoError(t, err)
+
+	resolver, err := NewOfflineResolver(dir, nil)
+	require.NoError(t, err)
+	defer resolver.Cleanup()
+
+	// the symbols of the debug file
+	id := elf.GNUBuildId(testBuildId)
+	assert.Equal(t, Symbol{
+		Start:      0x1150,
+		Name:       "iter",
+		Module:     buildIdPath,
+		Addr:       0x1150,
+		FuncStart:  0x1149,
+		FuncOffset: 7,
+		FileOffset: 0x1150,
+		BuildId:    id,
+	}, resolver.Resolve(id, 0x1150))
+	assert.Equal(t, "lib_iter@plt", resolver.Resolve(id, 0x1050).Name)
+
+	// the executable segment of go20 is loaded from offset 0 at 0x400000
+	sym := resolver.Resolve(goId, 0x817a0)
+	assert.Equal(t, "main.main", sym.Name)
+	assert.Equal(t, uint64(0x4817a0), sym.FuncStart)
+	assert.Equal(t, filepath.Join(dir, "bin", "go20"), sym.Module)
+
+	// unknown build ID
+	missing := elf.GNUBuildId("73e42a051f5f5e392c00b8d0fa95dc75c8b07458")
+	assert.Equal(t, Symbol{BuildId: missing, FileOffset: 0x1150}, resolver.Resolve(missing, 0x1150))
+
+	// binaries added to the directory are used once refreshed
+	copyTestFile(t, "elf/testdata/elfs/elf.dwarf", filepath.Join(dir, "elf.dwarf"))
+	assert.Empty(t, resolver.Resolve(missing, 0x1150).Name)
+	resolver.Refresh()
+	assert.NotEmpty(t, resolver.Resolve(missing, 0x1150).Module)
+
+	_, err = NewOfflineResolver(filepath.Join(dir, "none"), nil)
+	require.Error(t, err)
+}
+
+func TestOfflineResolver_Concurrent(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/executable") {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		<-release
+		http.ServeFile(w, r, "elf/testdata/elfs/elf.dwarf")
+	}))
+	defer srv.Close()
+	cache := t.TempDir()
+	client, err := NewDebuginfodClient(DebuginfodOptions{URLs: []string{srv.URL}, CacheDir: cache})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	copyTestFile(t, "elf/testdata/elfs/elf.stripped", filepath.Join(dir, ".build-id", "1f", "cfa068c5fdb9f31e6d9f3f89019beacb70182d"))
+	resolver, err := NewOfflineResolver(dir, &SymbolOptions{Debuginfod: client})
+	require.NoError(t, err)
+	defer resolver.Cleanup()
+	local := elf.GNUBuildId(testBuildId)
+	require.NotEmpty(t, resolver.Resolve(local, 0x1150).Module)
+
+	remote := elf.GNUBuildId("73e42a051f5f5e392c00b8d0fa95dc75c8b07458")
+	syms := make([]Symbol, 4)
+	var wg sync.WaitGroup
+	for i := range syms {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			syms[i] = resolver.Resolve(remote, 0x1150)
+		}(i)
+	}
+	// the modules already opened are resolved during the download
+	require.Eventually(t, func() bool { return requests.Load() > 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NotEmpty(t, resolver.Resolve(local, 0x1150).Module)
+	close(release)
+	wg.Wait()
+
+	// the binary is downloaded once
+	assert.Equal(t, int32(1), requests.Load())
+	for _, sym := range syms {
+		assert.NotEmpty(t, sym.Module)
+		assert.Equal(t, syms[0], sym)
+	}
+	// the debug files are looked up in the background
+	for _, id := range []elf.BuildId{local, remote} {
+		miss := filepath.Join(cache, id.Id, debuginfodDebugInfo+debuginfodMissSuffix)
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(miss)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestOfflineResolver_Deferred(t *testing.T) {
+	deferred, err := NewProcSymbol(unix.Getpid(), &SymbolOptions{Deferred: true})
+	require.NoError(t, err)
+	defer deferred.Cleanup()
+
+	malloc := getMallocAddr()
+	stack := deferred.ResolveStack([]uint64{malloc + 2, 0x10, malloc + 2})
+	require.Len(t, stack, 3)
+	sym := stack[0]
+	require.Empty(t, sym.Name)
+	require.False(t, sym.UnknownModule)
+	require.True(t, sym.BuildId.GNU())
+	// no symbol table nor build info is loaded
+	m := deferred.findModule(malloc)
+	require.NotNil(t, m)
+	assert.IsType(t, &emptyTable{}, m.table)
+	self := deferred.findModule(uint64(reflect.ValueOf(TestOfflineResolver_Deferred).Pointer()))
+	require.NotNil(t, self)
+	id := self.BuildId()
+	assert.False(t, id.Empty())
+	assert.Nil(t, self.GoBuildInfo())
+
+	// the libc of the host is the binary of the central box
+	dir := t.TempDir()
+	libc := filepath.Join(dir, ".build-id", sym.BuildId.Id[:2], sym.BuildId.Id[2:])
+	copyTestFile(t, sym.Module, libc)
+	resolver, err := NewOfflineResolver(dir, nil)
+	require.NoError(t, err)
+	defer resolver.Cleanup()
+
+	res := resolver.Symbolize(stack)
+	require.Len(t, res, 3)
+	assert.True(t, strings.Contains(res[0].Name, "malloc"), res[0].Name)
+	assert.Equal(t, malloc, res[0].FuncStart)
+	assert.Equal(t, uint64(2), res[0].FuncOffset)
+	// the process fields are kept
+	assert.Equal(t, sym.Module, res[0].Module)
+	assert.Equal(t, sym.Addr, res[0].Addr)
+	assert.Equal(t, sym.Mapping, res[0].Mapping)
+	assert.Equal(t, stack[1], res[1])
+	assert.Equal(t, res[0], res[2])
+}
@@ -0,0 +1,181 @@
+package syms
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// perfMapReloadInterval limits how often a miss triggers a check of the
+// perf map for new lines.
+var perfMapReloadInterval = time.Second
+
+// PerfMapTable resolves JIT-compiled code from a /tmp/perf-<pid>.map file,
+// with one `START SIZE symbolname` line (hex START and SIZE) per compiled
+// function. JIT runtimes only ever append to the file, so the table reads
+// the lines added since the previous load when an address is not found.
+// Lookups share mu, reloads hold it exclusively.
+type PerfMapTable struct {
+	mu         sync.RWMutex
+	path       string
+	entries    []perfMapEntry // sorted by start
+	offset     int64          // bytes of the file already parsed
+	lastReload time.Time
+	err        error
+}
+
+type perfMapEntry struct {
+	start uint64
+	size  uint64
+	name  string
+}
+
+func NewPerfMapTable(path string) *PerfMapTable {
+	t := &PerfMapTable{path: path}
+	t.reload()
+	return t
+}
+
+func (t *PerfMapTable) Resolve(addr uint64) string {
+	name, _ := t.ResolveStart(addr)
+	return name
+}
+
+func (t *PerfMapTable) ResolveStart(addr uint64) (string, uint64) {
+	t.mu.RLock()
+	name, start := t.lookup(addr)
+	reload := name == "" && time.Since(t.lastReload) >= perfMapReloadInterval
+	t.mu.RUnlock()
+	if !reload {
+		return name, start
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// another caller may have reloaded meanwhile
+	if time.Since(t.lastReload) >= perfMapReloadInterval {
+		t.reload()
+	}
+	return t.lookup(addr)
+}
+
+func (t *PerfMapTable) lookup(addr uint64) (string, uint64) {
+	i := sort.Search(len(t.entries), func(i int) bool { return addr < t.entries[i].start })
+	if i == 0 {
+		return "", 0
+	}
+	e := &t.entries[i-1]
+	if addr >= e.start+e.size {
+		return "", 0
+	}
+	return e.name, e.start
+}
+
+// reload parses the lines appended since the last load, it reports whether
+// new entries were added.
+func (t *PerfMapTable) reload() bool {
+	t.lastReload = time.Now()
+	f, err := os.Open(t.path)
+	if err != nil {
+		t.err = err
+		return false
+	}
+	defer f.Close()
+	t.err = nil
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if info.Size() < t.offset {
+		// the file was truncated, e.g. the process restarted with the same pid
+		glog.V(5).Infof("Perf map %s truncated, reloading", t.path)
+		t.entries = t.entries[:0]
+		t.offset = 0
+	}
+	if info.Size() == t.offset {
+		return false
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(f, t.offset, info.Size()-t.offset))
+	if err != nil {
+		glog.Warningf("Failed to read perf map %s: %v", t.path, err)
+		return false
+	}
+	// only consume complete lines, the JIT may be in the middle of a write
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return false
+	}
+	t.offset += int64(end + 1)
+
+	n := len(t.entries)
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		e, err := parsePerfMapLine(line)
+		if err != nil {
+			glog.V(5).Infof("Skip perf map %s line %q: %v", t.path, line, err)
+			continue
+		}
+		t.entries = append(t.entries, e)
+	}
+	if len(t.entries) == n {
+		return false
+	}
+	// Later lines win for code regions reused by the JIT. The stable sort
+	// keeps the file order of entries starting at the same address.
+	sort.SliceStable(t.entries, func(i, j int) bool { return t.entries[i].start < t.entries[j].start })
+	res := t.entries[:0]
+	for i := range t.entries {
+		if len(res) > 0 && res[len(res)-1].start == t.entries[i].start {
+			res[len(res)-1] = t.entries[i]
+			continue
+		}
+		res = append(res, t.entries[i])
+	}
+	t.entries = res
+	return true
+}
+
+func parsePerfMapLine(line string) (perfMapEntry, error) {
+	var e perfMapEntry
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) != 3 {
+		return e, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	var err error
+	if e.start, err = strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64); err != nil {
+		return e, fmt.Errorf("parse start: %w", err)
+	}
+	if e.size, err = strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64); err != nil {
+		return e, fmt.Errorf("parse size: %w", err)
+	}
+	e.name = strings.TrimSpace(fields[2])
+	return e, nil
+}
+
+func (t *PerfMapTable) Cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = nil
+	t.offset = 0
+}
+
+func (t *PerfMapTable) IsDead() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.err != nil
+}
+
+func (t *PerfMapTable) Size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.entries)
+}
@@ -0,0 +1,105 @@
+package syms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerfMapTable_Resolve(t *testing.T) {
+	interval := perfMapReloadInterval
+	perfMapReloadInterval = 0
+	t.Cleanup(func() { perfMapReloadInterval = interval })
+
+	path := filepath.Join(t.TempDir(), "perf-1234.map")
+	writePerfMap(t, path, os.O_CREATE|os.O_WRONLY,
+		"7f0000001000 40 LazyCompile:~main /app/index.js:1\n"+
+			"7f0000002000 0x20 Interpreter\n"+
+			"invalid line\n")
+
+	tbl := NewPerfMapTable(path)
+	assert.Equal(t, 2, tbl.Size())
+	assert.False(t, tbl.IsDead())
+
+	testcases := []struct {
+		addr uint64
+		name string
+	}{
+		{0x7f0000001000, "LazyCompile:~main /app/index.js:1"},
+		{0x7f000000103f, "LazyCompile:~main /app/index.js:1"},
+		{0x7f0000001040, ""},
+		{0x7f0000002010, "Interpreter"},
+		{0x7f0000000fff, ""},
+	}
+	for _, tt := range testcases {
+		assert.Equal(t, tt.name, tbl.Resolve(tt.addr), "addr 0x%x", tt.addr)
+	}
+	name, start := tbl.ResolveStart(0x7f0000002010)
+	assert.Equal(t, "Interpreter", name)
+	assert.Equal(t, uint64(0x7f0000002000), start)
+
+	// the JIT appends lines, a partial line is not consumed until completed
+	writePerfMap(t, path, os.O_APPEND|os.O_WRONLY, "7f0000003000 10 LazyCompile:*hot\n7f0000001000 80 Lazy")
+	assert.Equal(t, "LazyCompile:*hot", tbl.Resolve(0x7f0000003008))
+	assert.Equal(t, "", tbl.Resolve(0x7f0000001050))
+
+	// a recompilation at the same address replaces the previous entry
+	writePerfMap(t, path, os.O_APPEND|os.O_WRONLY, "Compile:*main\n")
+	assert.Equal(t, "LazyCompile:*main", tbl.Resolve(0x7f0000001050))
+	assert.Equal(t, "LazyCompile:*main", tbl.Resolve(0x7f0000001000))
+	assert.Equal(t, 3, tbl.Size())
+
+	// truncated by a new process with the same pid
+	writePerfMap(t, path, os.O_TRUNC|os.O_WRONLY, "1000 10 fresh\n")
+	assert.Equal(t, "fresh", tbl.Resolve(0x1000))
+	assert.Equal(t, 1, tbl.Size())
+
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, "", tbl.Resolve(0x2000))
+	assert.True(t, tbl.IsDead())
+}
+
+func TestPerfMapTable_Concurrent(t *testing.T) {
+	interval := perfMapReloadInterval
+	perfMapReloadInterval = 0
+	t.Cleanup(func() { perfMapReloadInterval = interval })
+
+	path := filepath.Join(t.TempDir(), "perf-1234.map")
+	writePerfMap(t, path, os.O_CREATE|os.O_WRONLY, "1000 10 first\n")
+	tbl := NewPerfMapTable(path)
+
+	// misses reload the map while the JIT appends to it
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, "first", tbl.Resolve(0x1008))
+				if name := tbl.Resolve(0x2000 + uint64(i)*0x10); name != "" {
+					assert.Equal(t, fmt.Sprintf("jit_%d", i), name)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		writePerfMap(t, path, os.O_APPEND|os.O_WRONLY, fmt.Sprintf("%x 10 jit_%d\n", 0x2000+i*0x10, i))
+	}
+	wg.Wait()
+	assert.Equal(t, "jit_99", tbl.Resolve(0x2000+99*0x10))
+	assert.Equal(t, 101, tbl.Size())
+}
+
+func writePerfMap(t *testing.T, path string, flag int, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, flag, 0o644)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+}
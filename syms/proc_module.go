@@ -3,25 +3,56 @@ package syms
 import (
 	delf "debug/elf"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/vietanhduong/profiling/proc"
 	"github.com/vietanhduong/profiling/syms/elf"
+	"golang.org/x/sys/unix"
 )
 
+// ProcModule is safe for concurrent use. The tables are loaded on first use
+// and resolved with mu held for reading, reloads hold it exclusively.
 type ProcModule struct {
+	mu      sync.RWMutex
 	name    string
 	loaded  bool
 	typ     ProcModuleType
 	table   SymbolTable
+	lines   *elf.DwarfTable
 	path    *procPath
 	opts    *SymbolOptions
 	base    uint64
 	procmap *proc.Map
+	// buildInfo is set for Go binaries
+	buildInfo *elf.BuildInfo
+	buildId   elf.BuildId
+	// frames resolves the lines of a Go module whose names come from the
+	// symbol cache
+	frames FrameTable
+	// shared is set when the tables are borrowed from SymbolOptions.Tables
+	shared *sharedTables
+	// fetching is set while the debug file of the module is downloaded, the
+	// tables loaded meanwhile are not shared
+	fetching atomic.Bool
+	// reload is set when the debug file of the module has been downloaded
+	// since the tables were loaded
+	reload atomic.Bool
+	// loadTime is when the tables were last loaded, dead tables are retried
+	// at most once per moduleRetryInterval
+	loadTime time.Time
 }
 
+// moduleRetryInterval limits how often the dead tables of a module are
+// reloaded on a miss.
+var moduleRetryInterval = time.Second
+
 func NewProcModule(name string, procmap *proc.Map, path *procPath, opts *SymbolOptions) *ProcModule {
 	if opts == nil {
 		opts = defaultSymbolOpts
@@ -39,31 +70,126 @@ func NewProcModule(name string, procmap *proc.Map, path *procPath, opts *SymbolO
 }
 
 func (m *ProcModule) Cleanup() {
-	m.table.Cleanup()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.releaseTables(false)
 	m.path.Close()
 	if m.typ == VDSO {
-		vstatus.Cleanup()
+		cleanupVDSO()
+	}
+}
+
+// rlock locks the module for reading, loading its tables first if needed.
+func (m *ProcModule) rlock() {
+	m.mu.RLock()
+	if m.loaded && !m.reload.Load() {
+		return
+	}
+	m.mu.RUnlock()
+	m.mu.Lock()
+	if m.loaded && m.reload.CompareAndSwap(true, false) {
+		glog.V(5).Infof("Reload table=%s with its downloaded debug file", m.name)
+		m.releaseTables(false)
+		m.table, m.lines, m.frames = &emptyTable{}, nil, nil
+		m.loaded = false
 	}
+	m.load()
+	m.mu.Unlock()
+	m.mu.RLock()
 }
 
 func (m *ProcModule) Resolve(addr uint64) string {
-	if !m.loaded {
+	name, _ := m.ResolveStart(addr)
+	return name
+}
+
+// ResolveStart returns the name of the symbol at addr and the address where
+// the symbol starts, 0 if the table does not know it.
+func (m *ProcModule) ResolveStart(addr uint64) (string, uint64) {
+	m.rlock()
+	base := m.base
+	sym, start := resolveStart(m.table, addr-base)
+	dead := sym == "" && m.retryDue()
+	m.mu.RUnlock()
+	if !dead {
+		return sym, rebase(start, base)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// another caller may have reloaded the tables meanwhile
+	if m.retryDue() {
+		glog.V(5).Infof("Retry table=%s type=%s", m.name, m.typ)
+		m.releaseTables(true)
+		m.loaded = false
 		m.load()
 	}
+	sym, start = resolveStart(m.table, addr-m.base)
+	return sym, rebase(start, m.base)
+}
+
+// retryDue reports whether the tables are dead and were not reloaded
+// within moduleRetryInterval, m.mu must be held.
+func (m *ProcModule) retryDue() bool {
+	return time.Since(m.loadTime) >= moduleRetryInterval && m.table.IsDead()
+}
+
+// BuildId returns the build ID of an ELF module, it is empty if the module
+// has none.
+func (m *ProcModule) BuildId() elf.BuildId {
+	m.rlock()
+	defer m.mu.RUnlock()
+	return m.buildId
+}
+
+// ResolveLine returns the source file and line of addr, from the Go
+// pclntab or from DWARF when SymbolOptions.UseDwarf is set.
+func (m *ProcModule) ResolveLine(addr uint64) (string, int) {
+	m.rlock()
+	defer m.mu.RUnlock()
+	addr -= m.base
+	if t := m.frameTable(); t != nil {
+		if file, line := t.ResolveLine(addr); file != "" {
+			return file, line
+		}
+	}
+	if m.lines == nil {
+		return "", 0
+	}
+	return m.lines.ResolveLine(addr)
+}
 
+// ResolveFrames returns the logical frames of addr, innermost first,
+// expanding functions inlined at addr. It returns nil when neither the Go
+// pclntab nor DWARF info cover addr.
+func (m *ProcModule) ResolveFrames(addr uint64) []elf.Frame {
+	m.rlock()
+	defer m.mu.RUnlock()
 	addr -= m.base
-	if sym := m.table.Resolve(addr); sym != "" {
-		return sym
+	if t := m.frameTable(); t != nil {
+		if frames := t.ResolveFrames(addr); len(frames) > 0 {
+			return frames
+		}
+	}
+	if m.lines == nil {
+		return nil
 	}
+	return m.lines.ResolveFrames(addr)
+}
 
-	if !m.table.IsDead() {
-		return ""
+func (m *ProcModule) frameTable() FrameTable {
+	if t, ok := m.table.(FrameTable); ok {
+		return t
 	}
+	return m.frames
+}
 
-	glog.Info("Retry table=%s type=%s", m.name, m.typ)
-	m.loaded = false
-	m.load()
-	return m.table.Resolve(addr)
+// GoBuildInfo returns the build information of a Go module, nil if the
+// module is not a Go binary or in deferred mode.
+func (m *ProcModule) GoBuildInfo() *elf.BuildInfo {
+	m.rlock()
+	defer m.mu.RUnlock()
+	return m.buildInfo
 }
 
 func (m *ProcModule) findbase(mf *elf.MMapedElfFile) bool {
@@ -71,10 +197,14 @@ func (m *ProcModule) findbase(mf *elf.MMapedElfFile) bool {
 		m.base = 0
 		return true
 	}
+	// Segments are mapped from page aligned offsets: a segment which does not
+	// start on a page boundary, as laid out by lld or in small 32-bit
+	// binaries, is mapped along with the preceding bytes of its page.
+	pageMask := uint64(os.Getpagesize() - 1)
 	for _, prog := range mf.Progs {
 		if prog.Type == delf.PT_LOAD && (prog.Flags&delf.PF_X != 0) {
-			if uint64(m.procmap.FileOffset) == prog.Off {
-				m.base = m.procmap.StartAddr - prog.Vaddr
+			if uint64(m.procmap.FileOffset) == prog.Off&^pageMask {
+				m.base = m.procmap.StartAddr - prog.Vaddr&^pageMask
 				return true
 			}
 		}
@@ -90,10 +220,18 @@ func (m *ProcModule) load() {
 		}
 		glog.V(5).Infof("Loaded symbol table (name=%s path=%s) has size: %d", m.name, m.path.GetPath(), m.table.Size())
 	}()
-	if m.loaded || m.typ == UNKNOWN {
+	if m.loaded {
 		return
 	}
 	m.loaded = true
+	m.loadTime = time.Now()
+	if m.typ == UNKNOWN {
+		return
+	}
+	// deferred modules only need the build ID and the base of ELF files
+	if m.opts.Deferred && m.typ != SO && m.typ != EXEC {
+		return
+	}
 
 	if m.typ == SO || m.typ == EXEC {
 		mf, err := elf.NewMMapedElfFile(m.path.GetPath())
@@ -103,29 +241,49 @@ func (m *ProcModule) load() {
 		}
 		defer mf.Close()
 
+		// deferred modules are read for their build ID only
+		if !m.opts.Deferred {
+			if bi, err := mf.GoBuildInfo(); err == nil {
+				m.buildInfo = bi
+			}
+		}
+		if id, err := mf.BuildId(); err == nil {
+			m.buildId = id
+		}
+
 		if !m.findbase(mf) {
 			glog.Warningf("Unable to determine base of elf path %s", m.path.GetPath())
 			return
 		}
-
-		opts := &elf.SymbolOptions{
-			DemangleOpts: m.opts.DemangleType.ToOptions(),
+		if m.opts.Deferred {
+			return
 		}
 
-		if m.opts.UseDebugFile {
-			if debugfile := m.findDebugFile(mf); debugfile != "" {
-				debugmf, err := elf.NewMMapedElfFile(debugfile)
-				if err != nil {
-					glog.Errorf("Failed to open mmaped debug file %s: %v", debugfile, err)
+		var key string
+		if m.opts.Tables != nil {
+			if key = tableStoreKey(mf, m.procmap, m.opts); key != "" {
+				if t := m.opts.Tables.acquire(key); t != nil {
+					m.borrow(t)
 					return
 				}
-				defer debugmf.Close()
-				m.table = createSymbolTable(debugmf, opts)
 			}
-			return
 		}
+		// tables missing their debug file are not shared, it may be found
+		// by the next process, e.g. through debuginfod
+		complete := m.loadElf(mf) && !m.fetching.Load()
+		if key != "" && complete && m.table != nil {
+			if t := m.share(key); t != nil {
+				m.borrow(t)
+			}
+		}
+	}
 
-		m.table = createSymbolTable(mf, opts)
+	if m.typ == PERFMAP {
+		m.table = NewPerfMapTable(m.name)
+	}
+
+	if m.typ == JITDUMP {
+		m.table = NewJitDumpTable(m.path.GetPath())
 	}
 
 	if m.typ == VDSO {
@@ -137,22 +295,237 @@ func (m *ProcModule) load() {
 	}
 }
 
+// loadElf loads the tables of the ELF module mf. It reports false when the
+// debug file of the module is used but was not found.
+func (m *ProcModule) loadElf(mf *elf.MMapedElfFile) bool {
+	opts := &elf.SymbolOptions{
+		DemangleOpts: m.opts.DemangleType.ToOptions(),
+		KeepRustHash: m.opts.KeepRustHash,
+	}
+
+	// the debug file is looked up once, for the lines and the symbols
+	debugFile := sync.OnceValue(func() string { return m.findDebugFile(mf) })
+	if m.opts.UseDwarf {
+		m.lines = m.loadDwarf(mf, debugFile, opts)
+	}
+
+	var cacheKey string
+	if m.opts.Cache != nil {
+		if id, err := mf.BuildId(); err == nil {
+			cacheKey = symbolCacheKey(id, m.opts)
+			if m.loadCached(mf, cacheKey) {
+				return true
+			}
+		}
+	}
+
+	// The symbols of the debug file are merged with the .dynsym, the PLT and
+	// the Go table of the module, which are missing from debug files.
+	if m.opts.UseDebugFile {
+		if debugfile := debugFile(); debugfile != "" {
+			debugmf, err := elf.NewMMapedElfFile(debugfile)
+			if err != nil {
+				glog.Errorf("Failed to open mmaped debug file %s: %v", debugfile, err)
+			} else {
+				defer debugmf.Close()
+				opts.DebugFile = debugmf
+			}
+		}
+		if opts.DebugFile == nil {
+			// the debug file may be found later, e.g. through debuginfod
+			cacheKey = ""
+		}
+	}
+
+	m.table = createSymbolTable(mf, opts)
+	m.storeCached(cacheKey)
+	return !m.opts.UseDebugFile || opts.DebugFile != nil
+}
+
+// share adds the tables of the module to the table store. Shared tables must
+// outlive the process: the symbols are materialized and the Go pclntab is
+// read through a file descriptor owned by the store.
+func (m *ProcModule) share(key string) *sharedTables {
+	t, ok := m.table.(elf.Table)
+	if !ok {
+		return nil
+	}
+	mt, materialized := t.(*elf.MaterializedTable)
+	if !materialized {
+		var err error
+		if mt, err = elf.Materialize(t); err != nil {
+			glog.Warningf("Failed to share symbol table (name=%s): %v", m.name, err)
+			return nil
+		}
+	}
+	var frames FrameTable
+	fd := -1
+	if mt.GoFuncs {
+		frames, fd = m.sharedGoFrames()
+	}
+	if !materialized {
+		m.table.Cleanup()
+	}
+	if t, ok := m.frames.(SymbolTable); ok {
+		t.Cleanup()
+	}
+	return m.opts.Tables.add(key, mt, m.lines, frames, fd)
+}
+
+// sharedGoFrames opens the Go pclntab of the module through a file
+// descriptor owned by the table, a duplicate of the descriptor of the module
+// or the module file opened once. The descriptor is returned to be closed
+// with the table, the file stays readable once the process exits.
+func (m *ProcModule) sharedGoFrames() (FrameTable, int) {
+	var fd int
+	var err error
+	if m.path.fd >= 0 {
+		fd, err = unix.Dup(m.path.fd)
+	} else {
+		fd, err = unix.Open(m.path.GetPath(), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	}
+	if err != nil {
+		glog.Warningf("Failed to share Go table (name=%s): %v", m.name, err)
+		return nil, -1
+	}
+	mf, err := elf.NewMMapedElfFile(proc.HostProcPath(fmt.Sprintf("self/fd/%d", fd)))
+	if err == nil {
+		defer mf.Close()
+		var gotbl *elf.GoTable
+		if gotbl, err = mf.NewGoTable(nil); err == nil {
+			return gotbl, fd
+		}
+	}
+	glog.Warningf("Failed to share Go table (name=%s): %v", m.name, err)
+	unix.Close(fd)
+	return nil, -1
+}
+
+// borrow uses the tables shared through the table store.
+func (m *ProcModule) borrow(t *sharedTables) {
+	m.shared = t
+	m.table, m.lines, m.frames = t.table, t.lines, t.frames
+}
+
+// releaseTables releases the tables of the module, dead tables are dropped
+// from the table store.
+func (m *ProcModule) releaseTables(dead bool) {
+	if m.shared != nil {
+		m.opts.Tables.release(m.shared, dead)
+		m.shared = nil
+		m.table, m.lines, m.frames = &emptyTable{}, nil, nil
+		return
+	}
+	if dead {
+		// kept as before, the table reopens its file
+		return
+	}
+	m.table.Cleanup()
+	if t, ok := m.frames.(SymbolTable); ok {
+		t.Cleanup()
+	}
+}
+
+// loadCached uses the symbol table stored under key in the symbol cache.
+// The cache only holds symbol names: the frame table of Go modules is rebuilt
+// from their pclntab, which indexes every function again.
+func (m *ProcModule) loadCached(mf *elf.MMapedElfFile, key string) bool {
+	t := m.opts.Cache.Load(key)
+	if t == nil {
+		return false
+	}
+	glog.V(5).Infof("Loaded symbol table (name=%s) from cache %s", m.name, key)
+	m.table = t
+	if t.GoFuncs {
+		if gotbl, err := mf.NewGoTable(nil); err == nil {
+			m.frames = gotbl
+		}
+	}
+	return true
+}
+
+// storeCached stores the symbol table in the symbol cache under key, if set.
+func (m *ProcModule) storeCached(key string) {
+	t, ok := m.table.(elf.Table)
+	if key == "" || !ok {
+		return
+	}
+	mt, err := elf.Materialize(t)
+	if err == nil {
+		err = m.opts.Cache.Store(key, mt)
+	}
+	if err != nil {
+		glog.Warningf("Failed to store symbol table (name=%s) in cache: %v", m.name, err)
+	}
+}
+
+// loadDwarf loads the DWARF lines of the module, or of its debug file when
+// the module has none.
+func (m *ProcModule) loadDwarf(mf *elf.MMapedElfFile, debugFile func() string, opts *elf.SymbolOptions) *elf.DwarfTable {
+	lines, err := mf.NewDwarfTable(opts)
+	if err == nil {
+		return lines
+	}
+	debugfile := debugFile()
+	if debugfile == "" {
+		glog.V(5).Infof("No DWARF info available (name=%s): %v", m.name, err)
+		return nil
+	}
+	debugmf, err := elf.NewMMapedElfFile(debugfile)
+	if err != nil {
+		glog.Errorf("Failed to open mmaped debug file %s: %v", debugfile, err)
+		return nil
+	}
+	defer debugmf.Close()
+	if lines, err = debugmf.NewDwarfTable(opts); err != nil {
+		glog.V(5).Infof("No DWARF info available (name=%s debugfile=%s): %v", m.name, debugfile, err)
+		return nil
+	}
+	return lines
+}
+
+// findDebugFile returns the host path of the debug file of the module.
 func (m *ProcModule) findDebugFile(mf *elf.MMapedElfFile) string {
 	id, _ := mf.BuildId()
 	if debugfile := m.findDebugFileViaBuildId(id); debugfile != "" {
 		return debugfile
 	}
-	return m.findDebugFileViaLink(mf)
+	if debugfile := m.findDebugFileViaLink(mf); debugfile != "" {
+		return debugfile
+	}
+	return m.findDebugFileViaDebuginfod(id)
+}
+
+// findDebugFileViaDebuginfod returns the debug file of the module if it is
+// in the debuginfod cache. Otherwise it is downloaded in the background, not
+// to block resolution, and the tables are reloaded once it is.
+func (m *ProcModule) findDebugFileViaDebuginfod(id elf.BuildId) string {
+	if m.opts.Debuginfod == nil || !id.GNU() {
+		return ""
+	}
+	if debugfile, ok := m.opts.Debuginfod.cached(id, debuginfodDebugInfo); ok {
+		return debugfile
+	}
+	m.fetching.Store(true)
+	m.opts.Debuginfod.fetchAsync(id, debuginfodDebugInfo, func(path string) {
+		m.fetching.Store(false)
+		if path != "" {
+			m.reload.Store(true)
+		}
+	})
+	return ""
 }
 
 func (m *ProcModule) findDebugFileViaBuildId(id elf.BuildId) string {
 	if len(id.Id) < 3 || !id.GNU() {
 		return ""
 	}
-	debugfile := fmt.Sprintf("/usr/lib/debug/.build-id/%s/%s.debug", id.Id[:2], id.Id[2:])
-	_, err := os.Stat(filepath.Join(m.path.procRootPath, debugfile))
-	if err == nil {
-		return debugfile
+	for _, dir := range m.debugDirs() {
+		debugfile := filepath.Join(dir, ".build-id", id.Id[:2], id.Id[2:]+".debug")
+		if _, err := os.Stat(debugfile); err == nil {
+			glog.V(5).Infof("Found debug file (name=%s) via build id: %s", m.name, debugfile)
+			return debugfile
+		}
 	}
 	return ""
 }
@@ -163,24 +536,105 @@ func (m *ProcModule) findDebugFileViaLink(mf *elf.MMapedElfFile) string {
 		return ""
 	}
 	debuglink := cstring(data.Data)
+	// the name is followed by padding up to 4 bytes and the CRC32 of the
+	// debug file
+	crcOff := (len(debuglink) + 4) &^ 3
+	if debuglink == "" || len(data.Data) < crcOff+4 {
+		return ""
+	}
+	crc := mf.ByteOrder.Uint32(data.Data[crcOff:])
 
-	dir := filepath.Dir(mf.FilePath())
+	dir := filepath.Dir(m.name)
 	paths := []string{
 		// /usr/bin/ls.debug
-		filepath.Join(dir, debuglink),
+		m.path.RootJoin(filepath.Join(dir, debuglink)),
 		// /usr/bin/.debug/ls.debug
-		filepath.Join(dir, ".debug", debuglink),
-		// /usr/bin/debug/usr/bin/ls.debug
-		filepath.Join("/usr/lib/debug", dir, debuglink),
+		m.path.RootJoin(filepath.Join(dir, ".debug", debuglink)),
+	}
+	for _, debugdir := range m.debugDirs() {
+		// /usr/lib/debug/usr/bin/ls.debug
+		paths = append(paths, filepath.Join(debugdir, dir, debuglink))
 	}
 	for _, p := range paths {
-		if _, err = os.Stat(filepath.Join(m.path.procRootPath, p)); err == nil {
-			return p
+		if _, err = os.Stat(p); err != nil {
+			continue
+		}
+		if err = checkDebugLinkCRC(p, crc); err != nil {
+			glog.V(5).Infof("Skipped debug file (name=%s) %s: %v", m.name, p, err)
+			continue
 		}
+		glog.V(5).Infof("Found debug file (name=%s) via debug link: %s", m.name, p)
+		return p
 	}
 	return ""
 }
 
+// debugDirs returns the host paths of the directories searched for debug
+// files.
+func (m *ProcModule) debugDirs() []string {
+	dirs := m.opts.DebugDirs
+	if len(dirs) == 0 {
+		dirs = []string{"/usr/lib/debug"}
+	}
+	res := make([]string, 0, len(dirs)+len(m.opts.HostDebugDirs))
+	for _, dir := range dirs {
+		res = append(res, m.path.RootJoin(dir))
+	}
+	return append(res, m.opts.HostDebugDirs...)
+}
+
+// debugFileKey identifies a debug file, which is reached through the root of
+// every process using it.
+type debugFileKey struct {
+	dev, ino    uint64
+	size, mtime int64
+}
+
+// debugLinkCRCs memoizes the CRC32 of the debug link candidates, which are
+// read in full, by debugFileKey.
+var debugLinkCRCs sync.Map
+
+func checkDebugLinkCRC(path string, crc uint32) error {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return err
+	}
+	key := debugFileKey{dev: uint64(st.Dev), ino: st.Ino, size: st.Size, mtime: st.Mtim.Nano()}
+	v, ok := debugLinkCRCs.Load(key)
+	if !ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := crc32.NewIEEE()
+		if _, err = io.Copy(h, f); err != nil {
+			return err
+		}
+		v, _ = debugLinkCRCs.LoadOrStore(key, h.Sum32())
+	}
+	if sum := v.(uint32); sum != crc {
+		return fmt.Errorf("crc32 mismatch: %08x, expected %08x", sum, crc)
+	}
+	return nil
+}
+
+func resolveStart(t SymbolTable, addr uint64) (string, uint64) {
+	if st, ok := t.(elf.StartTable); ok {
+		return st.ResolveStart(addr)
+	}
+	return t.Resolve(addr), 0
+}
+
+// rebase moves the start of a symbol from the module to the process
+// address space, 0 stays unknown.
+func rebase(start, base uint64) uint64 {
+	if start == 0 {
+		return 0
+	}
+	return start + base
+}
+
 func createSymbolTable(mf *elf.MMapedElfFile, opts *elf.SymbolOptions) SymbolTable {
 	gotbl, _ := mf.NewGoTable(nil)
 
@@ -198,7 +652,9 @@ func createSymbolTable(mf *elf.MMapedElfFile, opts *elf.SymbolOptions) SymbolTab
 		return nil
 	}
 	if gotbl != nil {
-		gotbl.SetFallback(symtbl)
+		if symtbl != nil {
+			gotbl.SetFallback(symtbl)
+		}
 		return gotbl
 	}
 	return symtbl
@@ -208,6 +664,12 @@ func getElfType(name string, path *procPath) ProcModuleType {
 	if proc.IsVDSO(name) {
 		return VDSO
 	}
+	if proc.IsPerfMap(name) {
+		return PERFMAP
+	}
+	if proc.IsJitDump(name) {
+		return JITDUMP
+	}
 
 	mf, _ := elf.NewMMapedElfFile(path.GetPath())
 	if mf != nil {
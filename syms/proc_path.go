@@ -14,11 +14,12 @@ import (
 type procPath struct {
 	path         string
 	procRootPath string
+	root         string
 	fd           int
 }
 
 func newProcPath(path string, pid, rootfd int, inMem bool) *procPath {
-	this := &procPath{}
+	this := &procPath{root: proc.HostProcRoot(pid), fd: -1}
 	if inMem {
 		this.path = path
 		this.procRootPath = path
@@ -47,4 +48,14 @@ func (p *procPath) GetPath() string {
 
 func (p *procPath) GetRootPath() string { return p.procRootPath }
 
-func (p *procPath) Close() { syscall.Close(p.fd) }
+// RootJoin returns the host path of a path inside the process root.
+func (p *procPath) RootJoin(path string) string { return filepath.Join(p.root, path) }
+
+// Close closes the file descriptor of the path once, the finalizer calls it
+// again.
+func (p *procPath) Close() {
+	if p.fd >= 0 {
+		syscall.Close(p.fd)
+		p.fd = -1
+	}
+}
@@ -1,8 +1,10 @@
 package syms
 
 import (
+	"cmp"
 	"fmt"
 	"slices"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/vietanhduong/profiling/proc"
@@ -13,12 +15,18 @@ type mrange struct {
 	module  *ProcModule
 }
 
+// ProcSymbol is safe for concurrent use. Resolving holds mu for reading,
+// Refresh and Cleanup hold it exclusively.
 type ProcSymbol struct {
+	mu      sync.RWMutex
 	pid     int
 	opts    *SymbolOptions
 	modules map[proc.File]*ProcModule
 	ranges  []mrange
-	stats   *proc.Stat
+	// perf maps and jitdumps of JIT runtimes, consulted for addresses no
+	// module resolves
+	jits  []*ProcModule
+	stats *proc.Stat
 }
 
 func NewProcSymbol(pid int, opts *SymbolOptions) (*ProcSymbol, error) {
@@ -41,12 +49,15 @@ func NewProcSymbol(pid int, opts *SymbolOptions) (*ProcSymbol, error) {
 }
 
 func (s *ProcSymbol) Refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for i := range s.ranges {
 		s.ranges[i].module = nil
 	}
 	s.ranges = s.ranges[:0]
+	s.jits = s.jits[:0]
 	if err := s.load(); err != nil {
-		glog.Error("Failed to refresh symbol: %v", err)
+		glog.Errorf("Failed to refresh symbol: %v", err)
 	}
 }
 
@@ -54,25 +65,160 @@ func (s *ProcSymbol) Resolve(addr uint64) Symbol {
 	if s.stats.IsStale() {
 		s.Refresh()
 	}
-	if addr == 0xcccccccccccccccc || addr == 0x9090909090909090 {
-		return Symbol{Start: 0, Name: "end_of_stack", Module: "[unknown]"}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, _ := s.findRange(addr, 0)
+	return s.resolve(addr, r)
+}
+
+func (s *ProcSymbol) ResolveFrames(addr uint64) []Symbol {
+	if s.stats.IsStale() {
+		s.Refresh()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, _ := s.findRange(addr, 0)
+	return s.resolveFrames(addr, r)
+}
+
+func (s *ProcSymbol) ResolveStack(addrs []uint64) []Symbol {
+	if len(addrs) == 0 {
+		return nil
+	}
+	if s.stats.IsStale() {
+		s.Refresh()
 	}
-	i, found := slices.BinarySearchFunc(s.ranges, addr, binarySearchRange)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Resolve the addresses in ascending order, so the range of the
+	// previous address is tried first and the ranges before it are never
+	// searched again. Recursive stacks repeat addresses, they are resolved
+	// once.
+	order := make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(addrs[a], addrs[b]) })
+	frames := make([][]Symbol, len(addrs))
+	var (
+		r     *mrange
+		from  int
+		total int
+	)
+	for k, i := range order {
+		addr := addrs[i]
+		if k > 0 && addrs[order[k-1]] == addr {
+			frames[i] = frames[order[k-1]]
+		} else {
+			if r == nil || !r.contains(addr) {
+				r, from = s.findRange(addr, from)
+			}
+			frames[i] = s.resolveFrames(addr, r)
+		}
+		total += len(frames[i])
+	}
+	res := make([]Symbol, 0, total)
+	for _, f := range frames {
+		res = append(res, f...)
+	}
+	return res
+}
+
+// findModule returns the module mapped at addr, s.mu must be held.
+func (s *ProcSymbol) findModule(addr uint64) *ProcModule {
+	if r, _ := s.findRange(addr, 0); r != nil {
+		return r.module
+	}
+	return nil
+}
+
+// findRange returns the range of s.ranges[from:] mapping addr, nil if there
+// is none, and the index of the first range which does not end before addr.
+// s.mu must be held.
+func (s *ProcSymbol) findRange(addr uint64, from int) (*mrange, int) {
+	i, found := slices.BinarySearchFunc(s.ranges[from:], addr, binarySearchRange)
+	i += from
 	if !found {
-		return Symbol{}
+		return nil, i
+	}
+	return &s.ranges[i], i
+}
+
+// resolveFrames returns the frames of addr mapped by r, which is nil if no
+// range maps addr.
+func (s *ProcSymbol) resolveFrames(addr uint64, r *mrange) []Symbol {
+	sym := s.resolve(addr, r)
+	if r == nil {
+		return []Symbol{sym}
 	}
-	r := s.ranges[i]
+	return moduleFrames(sym, r.module, addr)
+}
+
+// moduleFrames expands sym, the symbol of addr in the module m, with the
+// functions inlined at addr.
+func moduleFrames(sym Symbol, m *ProcModule, addr uint64) []Symbol {
+	if sym.Name == "" || m == nil {
+		return []Symbol{sym}
+	}
+	frames := m.ResolveFrames(addr)
+	if len(frames) <= 1 {
+		return []Symbol{sym}
+	}
+	res := make([]Symbol, len(frames))
+	for i, f := range frames {
+		res[i] = sym
+		res[i].Name, res[i].File, res[i].Line = f.Name, f.File, f.Line
+	}
+	// prefer the symbol table name (fully demangled) for the outermost frame
+	res[len(res)-1].Name = sym.Name
+	return res
+}
+
+// resolve returns the symbol of addr mapped by r, which is nil if no range
+// maps addr.
+func (s *ProcSymbol) resolve(addr uint64, r *mrange) Symbol {
+	if addr == 0xcccccccccccccccc || addr == 0x9090909090909090 {
+		return Symbol{Start: 0, Name: "end_of_stack", Module: "[unknown]", Addr: addr, UnknownModule: true}
+	}
+	sym := Symbol{Addr: addr, UnknownModule: true}
+	if r == nil {
+		return s.resolveJIT(addr, sym)
+	}
+	sym.Mapping = Mapping{Start: r.procmap.StartAddr, End: r.procmap.EndAddr, Offset: uint64(r.procmap.FileOffset)}
 	t := r.module
 	if t == nil {
-		return Symbol{}
+		return s.resolveJIT(addr, sym)
 	}
-	sym := t.Resolve(addr)
-	modoffset := addr - t.base
-	if sym == "" {
-		return Symbol{Start: modoffset, Module: r.procmap.Pathname}
+	name, start := t.ResolveStart(addr)
+	sym.Start = addr - t.base
+	sym.Module = r.procmap.Pathname
+	// anonymous mappings, the heap or the stack are not modules
+	sym.UnknownModule = t.typ == UNKNOWN
+	sym.FileOffset = addr - r.procmap.StartAddr + uint64(r.procmap.FileOffset)
+	sym.BuildId = t.BuildId()
+	if name == "" {
+		return s.resolveJIT(addr, sym)
 	}
 
-	return Symbol{Start: modoffset, Name: sym, Module: r.procmap.Pathname}
+	sym.Name = name
+	sym.setFuncStart(start)
+	sym.File, sym.Line = t.ResolveLine(addr)
+	return sym
+}
+
+// resolveJIT looks addr up in the perf maps and jitdumps, it returns
+// fallback if none of them knows addr.
+func (s *ProcSymbol) resolveJIT(addr uint64, fallback Symbol) Symbol {
+	for _, m := range s.jits {
+		if name, start := m.ResolveStart(addr); name != "" {
+			file, line := m.ResolveLine(addr)
+			sym := Symbol{Start: addr, Name: name, Module: m.name, File: file, Line: line, Addr: addr, Mapping: fallback.Mapping}
+			sym.setFuncStart(start)
+			return sym
+		}
+	}
+	return fallback
 }
 
 func (s *ProcSymbol) load() error {
@@ -82,6 +228,16 @@ func (s *ProcSymbol) load() error {
 	}
 	keeps := make(map[proc.File]struct{})
 	for _, m := range maps {
+		if proc.IsPerfMap(m.Pathname) || proc.IsJitDump(m.Pathname) {
+			// The JIT code they describe is not covered by their own range,
+			// perf maps are not even mapped into the process.
+			r := mrange{procmap: m}
+			if pm := s.getModule(&r); pm != nil {
+				s.jits = append(s.jits, pm)
+				keeps[m.File()] = struct{}{}
+			}
+			continue
+		}
 		s.ranges = append(s.ranges, mrange{procmap: m})
 		r := &s.ranges[len(s.ranges)-1]
 		if m := s.getModule(r); m != nil {
@@ -116,17 +272,25 @@ func (s *ProcSymbol) getModule(r *mrange) *ProcModule {
 }
 
 func (s *ProcSymbol) createModule(m *proc.Map) *ProcModule {
-	path := newProcPath(m.Pathname, s.pid, s.stats.GetRootFD(), m.InMem && s.pid != -1)
+	// perf map paths are already resolved on the host, see proc.FindPerfMapPath
+	inMem := (m.InMem || proc.IsPerfMap(m.Pathname)) && s.pid != -1
+	path := newProcPath(m.Pathname, s.pid, s.stats.GetRootFD(), inMem)
 	return NewProcModule(m.Pathname, m, path, s.opts)
 }
 
 func (s *ProcSymbol) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, t := range s.modules {
 		t.Cleanup()
 	}
 	clear(s.modules)
 }
 
+func (r *mrange) contains(addr uint64) bool {
+	return r.procmap.StartAddr <= addr && addr < r.procmap.EndAddr
+}
+
 func binarySearchRange(e mrange, addr uint64) int {
 	if addr < e.procmap.StartAddr {
 		return 1
@@ -1,10 +1,23 @@
 package syms
 
 import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+	"github.com/vietanhduong/profiling/syms/internal/testcgo"
 	"golang.org/x/sys/unix"
 )
 
@@ -20,3 +33,349 @@ func TestProcSym_Resolve(t *testing.T) {
 		t.Errorf("expected libc, got %v", res.Module)
 	}
 }
+
+func TestProcSym_ResolvePerfMap(t *testing.T) {
+	path := fmt.Sprintf("/tmp/perf-%d.map", unix.Getpid())
+	require.NoError(t, os.WriteFile(path, []byte("10000 100 LazyCompile:~handler /app/server.js:10\n"), 0o644))
+	t.Cleanup(func() { os.Remove(path) })
+
+	resolver, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(t, err, "Failed to new proc symbol resoler")
+	defer resolver.Cleanup()
+
+	res := resolver.Resolve(0x10010)
+	require.Equal(t, "LazyCompile:~handler /app/server.js:10", res.Name)
+	require.Equal(t, path, res.Module)
+	require.Empty(t, resolver.Resolve(0x10100).Name)
+}
+
+func TestProcModule_GoBuildInfo(t *testing.T) {
+	resolver, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(t, err, "Failed to new proc symbol resoler")
+	defer resolver.Cleanup()
+
+	m := resolver.findModule(uint64(reflect.ValueOf(TestProcModule_GoBuildInfo).Pointer()))
+	require.NotNil(t, m)
+	bi := m.GoBuildInfo()
+	require.NotNil(t, bi)
+	require.Equal(t, runtime.Version(), bi.GoVersion)
+
+	m = resolver.findModule(getMallocAddr())
+	require.NotNil(t, m)
+	require.Nil(t, m.GoBuildInfo())
+}
+
+func TestProcModule_Resolve32(t *testing.T) {
+	// the executable segment of elf.386 starts at file offset 0x24c, it is
+	// mapped from offset 0 at base+0x1000
+	const base = 0x56555000
+	path := "elf/testdata/elfs/elf.386"
+	m := NewProcModule(path, &proc.Map{StartAddr: base + 0x1000, EndAddr: base + 0x2000}, newProcPath(path, 0, -1, true), nil)
+	defer m.Cleanup()
+
+	require.Equal(t, "iter", m.Resolve(base+0x124c))
+	require.Equal(t, "_start", m.Resolve(base+0x126a))
+	require.Equal(t, "lib_iter@plt", m.Resolve(base+0x12a0))
+}
+
+func TestProcModule_GoInlinedFrames(t *testing.T) {
+	// the funcdata of Go 1.18+ binaries is found once the file is closed
+	testcases := []struct {
+		path   string
+		frames []elf.Frame
+	}{
+		{"elf/testdata/elfs/go18", []elf.Frame{
+			{Name: "internal/cpu.indexByte", File: "/usr/local/go/src/internal/cpu/cpu.go", Line: 214},
+			{Name: "internal/cpu.processOptions", File: "/usr/local/go/src/internal/cpu/cpu.go", Line: 151},
+		}},
+		{"elf/testdata/elfs/go20", []elf.Frame{
+			{Name: "internal/cpu.indexByte", File: "/usr/local/go/src/internal/cpu/cpu.go", Line: 217},
+			{Name: "internal/cpu.processOptions", File: "/usr/local/go/src/internal/cpu/cpu.go", Line: 154},
+		}},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.path, func(t *testing.T) {
+			m := NewProcModule(tt.path, &proc.Map{StartAddr: 0x401000}, newProcPath(tt.path, 0, -1, true), nil)
+			defer m.Cleanup()
+			require.Equal(t, tt.frames, m.ResolveFrames(0x4010a2))
+		})
+	}
+}
+
+func TestProcModule_FindDebugFile(t *testing.T) {
+	copyFile := func(src, dst string) string {
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+		require.NoError(t, os.WriteFile(dst, data, 0o644))
+		return dst
+	}
+	root, err := unix.Open("/", unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	require.NoError(t, err)
+	defer unix.Close(root)
+	findDebugFile := func(path string, opts *SymbolOptions) string {
+		m := NewProcModule(path, &proc.Map{}, newProcPath(path, unix.Getpid(), root, false), opts)
+		defer m.Cleanup()
+		mf, err := elf.NewMMapedElfFile(path)
+		require.NoError(t, err)
+		defer mf.Close()
+		return m.findDebugFile(mf)
+	}
+
+	bin := t.TempDir()
+	stripped := copyFile("elf/testdata/elfs/elf.stripped", filepath.Join(bin, "elf.stripped"))
+	debuglink := copyFile("elf/testdata/elfs/elf.debuglink", filepath.Join(bin, "elf.debuglink"))
+	buildIdPath := filepath.Join(".build-id", "1f", "cfa068c5fdb9f31e6d9f3f89019beacb70182d.debug")
+
+	t.Run("build id", func(t *testing.T) {
+		require.Empty(t, findDebugFile(stripped, nil))
+
+		host := t.TempDir()
+		expected := copyFile("elf/testdata/elfs/elf.debug", filepath.Join(host, buildIdPath))
+		require.Equal(t, expected, findDebugFile(stripped, &SymbolOptions{HostDebugDirs: []string{host}}))
+
+		// paths of the process filesystem are searched first
+		container := t.TempDir()
+		copyFile("elf/testdata/elfs/elf.debug", filepath.Join(container, buildIdPath))
+		require.Equal(t, proc.HostProcPath(fmt.Sprintf("%d/root", unix.Getpid()), container, buildIdPath),
+			findDebugFile(stripped, &SymbolOptions{DebugDirs: []string{container}, HostDebugDirs: []string{host}}))
+	})
+
+	t.Run("debug link", func(t *testing.T) {
+		require.Empty(t, findDebugFile(debuglink, nil))
+
+		// candidates whose CRC32 does not match are skipped
+		invalid := t.TempDir()
+		copyFile("elf/testdata/elfs/elf.dwarf.debug", filepath.Join(bin, ".debug", "elf.debug"))
+		copyFile("elf/testdata/elfs/elf.dwarf.debug", filepath.Join(invalid, bin, "elf.debug"))
+		require.Empty(t, findDebugFile(debuglink, &SymbolOptions{HostDebugDirs: []string{invalid}}))
+
+		host := t.TempDir()
+		expected := copyFile("elf/testdata/elfs/elf.debug", filepath.Join(host, bin, "elf.debug"))
+		require.Equal(t, expected, findDebugFile(debuglink, &SymbolOptions{HostDebugDirs: []string{invalid, host}}))
+	})
+}
+
+func TestCheckDebugLinkCRC(t *testing.T) {
+	data, err := os.ReadFile("elf/testdata/elfs/elf.debug")
+	require.NoError(t, err)
+	debugfile := filepath.Join(t.TempDir(), "elf.debug")
+	require.NoError(t, os.WriteFile(debugfile, data, 0o644))
+	crc := crc32.ChecksumIEEE(data)
+	require.NoError(t, checkDebugLinkCRC(debugfile, crc))
+
+	// the CRC32 is memoized until the file changes
+	st, err := os.Stat(debugfile)
+	require.NoError(t, err)
+	corrupted := bytes.Clone(data)
+	corrupted[0] ^= 0xff
+	require.NoError(t, os.WriteFile(debugfile, corrupted, 0o644))
+	require.NoError(t, os.Chtimes(debugfile, st.ModTime(), st.ModTime()))
+	require.NoError(t, checkDebugLinkCRC(debugfile, crc))
+
+	require.NoError(t, os.Chtimes(debugfile, st.ModTime(), st.ModTime().Add(time.Second)))
+	require.Error(t, checkDebugLinkCRC(debugfile, crc))
+}
+
+func TestProcModule_DebugFile(t *testing.T) {
+	debugDir := t.TempDir()
+	data, err := os.ReadFile("elf/testdata/elfs/elf.debug")
+	require.NoError(t, err)
+	debugfile := filepath.Join(debugDir, ".build-id", "1f", "cfa068c5fdb9f31e6d9f3f89019beacb70182d.debug")
+	require.NoError(t, os.MkdirAll(filepath.Dir(debugfile), 0o755))
+	require.NoError(t, os.WriteFile(debugfile, data, 0o644))
+	opts := &SymbolOptions{DemangleType: DemangleFull, UseDebugFile: true, HostDebugDirs: []string{debugDir}}
+
+	const base = 0x555555554000
+	elfMap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+	testcases := []struct {
+		path    string
+		procmap *proc.Map
+		addr    uint64
+		name    string
+	}{
+		// symbols of the debug file
+		{"elf/testdata/elfs/elf.stripped", elfMap, base + 0x1149, "iter"},
+		// the PLT of the module
+		{"elf/testdata/elfs/elf.stripped", elfMap, base + 0x1050, "lib_iter@plt"},
+		// modules without debug file
+		{"elf/testdata/elfs/elf.nobuildid", elfMap, base + 0x1149, "iter"},
+		{"elf/testdata/elfs/go20", &proc.Map{StartAddr: 0x401000}, 0x4817a0, "main.main"},
+	}
+	for _, tt := range testcases {
+		t.Run(fmt.Sprintf("%s/%s", tt.path, tt.name), func(t *testing.T) {
+			m := NewProcModule(tt.path, tt.procmap, newProcPath(tt.path, 0, -1, true), opts)
+			defer m.Cleanup()
+			require.Equal(t, tt.name, m.Resolve(tt.addr))
+		})
+	}
+}
+
+func TestProcSymbol_Concurrent(t *testing.T) {
+	addrs := []uint64{
+		getMallocAddr(),
+		uint64(reflect.ValueOf(TestProcSymbol_Concurrent).Pointer()),
+		uint64(reflect.ValueOf(elf.NewMMapedElfFile).Pointer()) + 1,
+	}
+	serial, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(t, err)
+	defer serial.Cleanup()
+	expected := make([]Symbol, len(addrs))
+	for i, addr := range addrs {
+		expected[i] = serial.Resolve(addr)
+		require.NotEmpty(t, expected[i].Name)
+	}
+
+	// resolvers sharing tables load them concurrently
+	opts := &SymbolOptions{DemangleType: DemangleFull, Tables: NewTableStore(1 << 30)}
+	var resolvers []*ProcSymbol
+	for i := 0; i < 2; i++ {
+		resolver, err := NewProcSymbol(unix.Getpid(), opts)
+		require.NoError(t, err)
+		defer resolver.Cleanup()
+		resolvers = append(resolvers, resolver)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		resolver := resolvers[g%len(resolvers)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				for j, addr := range addrs {
+					assert.Equal(t, expected[j], resolver.Resolve(addr))
+					frames := resolver.ResolveFrames(addr)
+					assert.Equal(t, expected[j].Name, frames[len(frames)-1].Name)
+				}
+			}
+		}()
+	}
+	for _, resolver := range resolvers {
+		wg.Add(1)
+		go func(resolver *ProcSymbol) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				resolver.Refresh()
+			}
+		}(resolver)
+	}
+	wg.Wait()
+}
+
+// cxxStack returns a deep stack of libstdc++ functions, recursive like the
+// stacks of template heavy C++ code, innermost first.
+func cxxStack(tb testing.TB) []uint64 {
+	var funcs []uint64
+	for _, name := range []string{
+		"_ZNSi6ignoreEl",
+		"_ZNSi7getlineEPclc",
+		"_ZNSs7_M_copyEPcPKcm",
+		"_ZNSs9_M_assignEPcmc",
+		"_ZNSt6locale11_M_coalesceERKS_S1_i",
+		"_ZNSt6locale5_Impl16_M_install_facetEPKNS_2idEPKNS_5facetE",
+	} {
+		if addr := testcgo.CxxAddr(name); addr != 0 {
+			funcs = append(funcs, addr+4)
+		}
+	}
+	if len(funcs) == 0 {
+		tb.Skip("libstdc++ not found")
+	}
+	stack := []uint64{getMallocAddr()}
+	for i := 0; i < 120; i++ {
+		stack = append(stack, funcs[i%len(funcs)]+uint64(i%3))
+	}
+	return append(stack, uint64(reflect.ValueOf(cxxStack).Pointer()))
+}
+
+func TestProcSymbol_ResolveStack(t *testing.T) {
+	// libstdc++ is loaded before the maps are read
+	stack := append(cxxStack(t), 0x10, 0xcccccccccccccccc)
+	resolver, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(t, err)
+	defer resolver.Cleanup()
+
+	var expected []Symbol
+	for _, addr := range stack {
+		expected = append(expected, resolver.ResolveFrames(addr)...)
+	}
+	res := resolver.ResolveStack(stack)
+	require.Equal(t, expected, res)
+	assert.Contains(t, res[1].Module, "libstdc++")
+	assert.Equal(t, "std::istream::ignore(long)", res[1].Name)
+	assert.Empty(t, resolver.ResolveStack(nil))
+}
+
+// BenchmarkProcSymbol_Stack compares resolving a deep C++ stack frame by
+// frame with resolving it at once.
+func BenchmarkProcSymbol_Stack(b *testing.B) {
+	stack := cxxStack(b)
+	resolver, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(b, err)
+	defer resolver.Cleanup()
+	resolver.ResolveStack(stack)
+
+	b.Run("frames", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, addr := range stack {
+				resolver.ResolveFrames(addr)
+			}
+		}
+	})
+	b.Run("stack", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resolver.ResolveStack(stack)
+		}
+	})
+}
+
+func TestProcSymbol_Symbol(t *testing.T) {
+	const base = 0x555555554000
+	procmap := &proc.Map{Pathname: "/bin/elf", StartAddr: 0x555555555000, EndAddr: 0x555555556000, FileOffset: 0x1000}
+	mapping := Mapping{Start: 0x555555555000, End: 0x555555556000, Offset: 0x1000}
+	newRange := func(path string) *mrange {
+		m := NewProcModule(procmap.Pathname, procmap, newProcPath(path, 0, -1, true), nil)
+		t.Cleanup(m.Cleanup)
+		return &mrange{procmap: procmap, module: m}
+	}
+	s := &ProcSymbol{}
+
+	sym := s.resolve(base+0x1150, newRange("elf/testdata/elfs/elf"))
+	assert.Equal(t, Symbol{
+		Start:      0x1150,
+		Name:       "iter",
+		Module:     "/bin/elf",
+		Addr:       base + 0x1150,
+		FuncStart:  base + 0x1149,
+		FuncOffset: 7,
+		FileOffset: 0x1150,
+		BuildId:    elf.GNUBuildId(testBuildId),
+		Mapping:    mapping,
+	}, sym)
+
+	// known module without symbol
+	sym = s.resolve(base+0x1150, newRange("elf/testdata/elfs/elf.stripped"))
+	assert.Equal(t, Symbol{
+		Start:      0x1150,
+		Module:     "/bin/elf",
+		Addr:       base + 0x1150,
+		FileOffset: 0x1150,
+		BuildId:    elf.GNUBuildId(testBuildId),
+		Mapping:    mapping,
+	}, sym)
+
+	// unknown module
+	assert.Equal(t, Symbol{Addr: 0x10, UnknownModule: true}, s.resolve(0x10, nil))
+
+	resolver, err := NewProcSymbol(unix.Getpid(), nil)
+	require.NoError(t, err)
+	defer resolver.Cleanup()
+	malloc := getMallocAddr()
+	sym = resolver.Resolve(malloc + 2)
+	assert.Equal(t, malloc, sym.FuncStart)
+	assert.Equal(t, uint64(2), sym.FuncOffset)
+	assert.False(t, sym.UnknownModule)
+	assert.True(t, sym.Mapping.Start <= sym.Addr && sym.Addr < sym.Mapping.End)
+	assert.Equal(t, sym.Addr-sym.Mapping.Start+sym.Mapping.Offset, sym.FileOffset)
+	assert.True(t, resolver.Resolve(0x10).UnknownModule)
+}
@@ -0,0 +1,177 @@
+package syms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/vietanhduong/profiling/syms/elf"
+)
+
+const symbolCacheExt = ".symtab"
+
+// SymbolCache persists the symbol tables of ELF files in a directory, keyed
+// by build ID, so that the tables of a binary are parsed and demangled once
+// across processes and agent restarts. Entries hold the symbol names only:
+// the frame tables of Go modules and the DWARF lines are not persisted.
+//
+// The least recently used entries are removed once the directory grows over
+// maxSize bytes.
+type SymbolCache struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+	// size of the directory, -1 if unknown
+	size int64
+}
+
+// NewSymbolCache returns a cache storing up to maxSize bytes in dir, which is
+// created if needed.
+func NewSymbolCache(dir string, maxSize int64) (*SymbolCache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid symbol cache size %d", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create symbol cache dir: %w", err)
+	}
+	return &SymbolCache{dir: dir, maxSize: maxSize, size: -1}, nil
+}
+
+// symbolCacheKey returns the key of the table of the file with the build ID
+// id, names depend on the options.
+func symbolCacheKey(id elf.BuildId, opts *SymbolOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t\x00%t", id.Typ, id.Id, opts.DemangleType, opts.KeepRustHash, opts.UseDebugFile)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (c *SymbolCache) path(key string) string {
+	return filepath.Join(c.dir, key+symbolCacheExt)
+}
+
+// Load returns the table stored under key, nil if there is none or if it is
+// corrupted.
+func (c *SymbolCache) Load(key string) *elf.MaterializedTable {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			glog.Warningf("Failed to read symbol cache %s: %v", path, err)
+		}
+		return nil
+	}
+	res := &elf.MaterializedTable{}
+	if err = res.UnmarshalBinary(data); err != nil {
+		glog.Warningf("Invalid symbol cache %s: %v", path, err)
+		c.remove(path)
+		return nil
+	}
+	// the modification time orders entries for eviction
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return res
+}
+
+// Store writes table under key and evicts the least recently used entries
+// beyond the size limit.
+func (c *SymbolCache) Store(key string, table *elf.MaterializedTable) error {
+	data, err := table.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > c.maxSize {
+		return fmt.Errorf("symbol table of %d bytes exceeds the cache size", len(data))
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create symbol cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write symbol cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	old, _ := os.Stat(path)
+	// readers see either the previous or the new entry
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write symbol cache entry: %w", err)
+	}
+	if c.size >= 0 {
+		c.size += int64(len(data))
+		if old != nil {
+			c.size -= old.Size()
+		}
+	}
+	c.evict()
+	return nil
+}
+
+func (c *SymbolCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if os.Remove(path) == nil {
+		c.size = -1
+	}
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxSize. Entries may be shared with other agents, the size is measured
+// again when unknown.
+func (c *SymbolCache) evict() {
+	if c.size >= 0 && c.size <= c.maxSize {
+		return
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		glog.Warningf("Failed to list symbol cache %s: %v", c.dir, err)
+		return
+	}
+	type entry struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var files []entry
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), symbolCacheExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry{filepath.Join(c.dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			glog.Warningf("Failed to evict symbol cache %s: %v", f.path, err)
+			continue
+		}
+		glog.V(5).Infof("Evicted symbol cache %s (%d bytes)", f.path, f.size)
+		total -= f.size
+	}
+	c.size = total
+}
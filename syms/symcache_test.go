@@ -0,0 +1,117 @@
+package syms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+	"github.com/vietanhduong/profiling/syms/gosym"
+)
+
+func testMaterializedTable(names ...string) *elf.MaterializedTable {
+	t := &elf.MaterializedTable{
+		Values: gosym.NewPCIndex(len(names)),
+		Sizes:  gosym.NewPCIndex(len(names)),
+		Names:  names,
+	}
+	for i := range names {
+		t.Values.Set(i, uint64(0x1000*(i+1)))
+		t.Sizes.Set(i, 0x100)
+	}
+	return t
+}
+
+func TestSymbolCache_StoreLoad(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewSymbolCache(dir, 1<<20)
+	require.NoError(t, err)
+
+	assert.Nil(t, cache.Load("missing"))
+	require.NoError(t, cache.Store("key", testMaterializedTable("foo", "bar")))
+	res := cache.Load("key")
+	require.NotNil(t, res)
+	assert.Equal(t, "bar", res.Resolve(0x2010))
+	assert.Equal(t, "", res.Resolve(0x2100))
+
+	// corrupted entries are dropped
+	path := filepath.Join(dir, "key"+symbolCacheExt)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-5] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.Nil(t, cache.Load("key"))
+	assert.NoFileExists(t, path)
+}
+
+func TestSymbolCache_Evict(t *testing.T) {
+	dir := t.TempDir()
+	data, err := testMaterializedTable("foo").MarshalBinary()
+	require.NoError(t, err)
+	// room for two entries
+	cache, err := NewSymbolCache(dir, int64(2*len(data)))
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Store("a", testMaterializedTable("foo")))
+	require.NoError(t, cache.Store("b", testMaterializedTable("bar")))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "b"+symbolCacheExt), old, old))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a"+symbolCacheExt), old.Add(-time.Hour), old.Add(-time.Hour)))
+	// a is used again, b is now the least recently used entry
+	require.NotNil(t, cache.Load("a"))
+	require.NoError(t, cache.Store("c", testMaterializedTable("baz")))
+
+	assert.NotNil(t, cache.Load("a"))
+	assert.Nil(t, cache.Load("b"))
+	assert.NotNil(t, cache.Load("c"))
+
+	// too large for the cache
+	assert.Error(t, cache.Store("d", testMaterializedTable("foo", "bar", "baz", "qux")))
+}
+
+func TestProcModule_SymbolCache(t *testing.T) {
+	cache, err := NewSymbolCache(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	opts := &SymbolOptions{DemangleType: DemangleFull, Cache: cache}
+
+	testcases := []struct {
+		path    string
+		procmap *proc.Map
+		addr    uint64
+		name    string
+		line    bool
+	}{
+		{"elf/testdata/elfs/elf", &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}, 0x555555554000 + 0x1149, "iter", false},
+		{"elf/testdata/elfs/go20", &proc.Map{StartAddr: 0x401000}, 0x4817a0, "main.main", true},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.path, func(t *testing.T) {
+			newModule := func() *ProcModule {
+				m := NewProcModule(tt.path, tt.procmap, newProcPath(tt.path, 0, -1, true), opts)
+				t.Cleanup(m.Cleanup)
+				return m
+			}
+			m := newModule()
+			require.Equal(t, tt.name, m.Resolve(tt.addr))
+			_, ok := m.table.(*elf.MaterializedTable)
+			require.False(t, ok)
+			file, line := m.ResolveLine(tt.addr)
+
+			// the second module reads the table stored by the first one
+			cached := newModule()
+			require.Equal(t, tt.name, cached.Resolve(tt.addr))
+			_, ok = cached.table.(*elf.MaterializedTable)
+			require.True(t, ok)
+			cachedFile, cachedLine := cached.ResolveLine(tt.addr)
+			assert.Equal(t, file, cachedFile)
+			assert.Equal(t, line, cachedLine)
+			if tt.line {
+				assert.NotEmpty(t, cachedFile)
+			}
+		})
+	}
+}
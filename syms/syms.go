@@ -1,7 +1,20 @@
 package syms
 
+// Resolver implementations are safe for concurrent use: Resolve and
+// ResolveFrames may be called from many goroutines, e.g. the per sample
+// goroutines of ring.Spec{Async: true}, while Refresh runs. Cleanup must be
+// the last call.
 type Resolver interface {
 	Resolve(addr uint64) Symbol
+	// ResolveFrames returns the logical frames of addr, innermost first.
+	// Functions inlined at addr get their own frames, the last frame is
+	// the function reported by Resolve.
+	ResolveFrames(addr uint64) []Symbol
+	// ResolveStack returns the logical frames of the stack addrs, innermost
+	// first, as ResolveFrames of each address would. It checks the
+	// resolver is up to date once and resolves each distinct address once,
+	// which is cheaper than resolving the frames one by one.
+	ResolveStack(addrs []uint64) []Symbol
 	Cleanup()
 	Refresh()
 }
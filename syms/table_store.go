@@ -0,0 +1,220 @@
+package syms
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+	"github.com/vietanhduong/profiling/syms/gosym"
+	"golang.org/x/sys/unix"
+)
+
+// TableStore shares the tables of ELF modules between the ProcSymbol
+// resolvers of a process, so that a library mapped by many processes is
+// loaded once. Tables are reference counted, unreferenced tables are kept
+// until the estimated memory of the store exceeds its budget and evicted
+// least recently used first.
+type TableStore struct {
+	budget int64
+
+	mu     sync.Mutex
+	tables map[string]*sharedTables
+	// unreferenced tables, most recently released first
+	lru   list.List
+	size  int64
+	stats TableStoreStats
+}
+
+// TableStoreStats reports the activity of a TableStore.
+type TableStoreStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Tables is the number of stored tables and Size their estimated
+	// memory in bytes.
+	Tables int
+	Size   int64
+}
+
+// sharedTables are the tables of a module, borrowed by ProcModule.
+type sharedTables struct {
+	key    string
+	table  SymbolTable
+	lines  *elf.DwarfTable
+	frames FrameTable
+	// file descriptor read by frames, -1 if none
+	fd   int
+	size int64
+	refs int
+	// position in the lru, nil while referenced
+	elem *list.Element
+}
+
+// NewTableStore returns a store keeping unreferenced tables up to an
+// estimated budget bytes.
+func NewTableStore(budget int64) *TableStore {
+	return &TableStore{budget: budget, tables: make(map[string]*sharedTables)}
+}
+
+// tableStoreKey identifies the tables of the module mf mapped by procmap. It
+// returns "" if the file can't be identified.
+func tableStoreKey(mf *elf.MMapedElfFile, procmap *proc.Map, opts *SymbolOptions) string {
+	var file string
+	if id, err := mf.BuildId(); err == nil {
+		file = fmt.Sprintf("%s:%s", id.Typ, id.Id)
+	} else if procmap != nil && procmap.Inode != 0 {
+		// inodes are reused once files are deleted, the size and the
+		// modification and change times tell the files apart
+		var st unix.Stat_t
+		if err := unix.Stat(mf.FilePath(), &st); err != nil {
+			return ""
+		}
+		file = fmt.Sprintf("inode:%d:%d:%d:%d:%d:%d", procmap.DevMajor, procmap.DevMinor, procmap.Inode,
+			st.Size, st.Mtim.Nano(), st.Ctim.Nano())
+	} else {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s:%t:%t:%t", file, opts.DemangleType, opts.KeepRustHash, opts.UseDebugFile, opts.UseDwarf)
+}
+
+// acquire returns a reference to the tables stored under key, nil if there
+// are none.
+func (s *TableStore) acquire(key string) *sharedTables {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tables[key]
+	if !ok {
+		s.stats.Misses++
+		return nil
+	}
+	s.stats.Hits++
+	if t.elem != nil {
+		s.lru.Remove(t.elem)
+		t.elem = nil
+	}
+	t.refs++
+	return t
+}
+
+// add stores the tables of a module under key and returns a reference to
+// them. If another module stored tables under key meanwhile, they are used
+// instead and the given tables are cleaned up.
+func (s *TableStore) add(key string, table SymbolTable, lines *elf.DwarfTable, frames FrameTable, fd int) *sharedTables {
+	t := &sharedTables{key: key, table: table, lines: lines, frames: frames, fd: fd, refs: 1}
+	t.size = tableMemSize(table) + tableMemSize(frames)
+	if lines != nil {
+		t.size += lines.MemSize()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.tables[key]; ok {
+		if prev.elem != nil {
+			s.lru.Remove(prev.elem)
+			prev.elem = nil
+		}
+		prev.refs++
+		t.cleanup()
+		return prev
+	}
+	s.tables[key] = t
+	s.size += t.size
+	s.evict()
+	return t
+}
+
+// release drops a reference to t. Dead tables are removed from the store
+// right away.
+func (s *TableStore) release(t *sharedTables, dead bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.refs--
+	if dead && s.tables[t.key] == t {
+		s.remove(t)
+	}
+	if t.refs > 0 {
+		return
+	}
+	if s.tables[t.key] != t {
+		// removed while referenced
+		t.cleanup()
+		return
+	}
+	t.elem = s.lru.PushFront(t)
+	s.evict()
+}
+
+// evict removes the least recently used unreferenced tables while the store
+// is over budget. Referenced tables are never evicted.
+func (s *TableStore) evict() {
+	for s.size > s.budget && s.lru.Len() > 0 {
+		t := s.lru.Back().Value.(*sharedTables)
+		glog.V(5).Infof("Evicted shared symbol table %s (%d bytes)", t.key, t.size)
+		s.remove(t)
+		s.stats.Evictions++
+		t.cleanup()
+	}
+}
+
+func (s *TableStore) remove(t *sharedTables) {
+	if t.elem != nil {
+		s.lru.Remove(t.elem)
+		t.elem = nil
+	}
+	delete(s.tables, t.key)
+	s.size -= t.size
+}
+
+// Stats returns the counters of the store.
+func (s *TableStore) Stats() TableStoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.stats
+	res.Tables = len(s.tables)
+	res.Size = s.size
+	return res
+}
+
+func (t *sharedTables) cleanup() {
+	t.table.Cleanup()
+	if c, ok := t.frames.(SymbolTable); ok {
+		c.Cleanup()
+	}
+	if t.fd >= 0 {
+		unix.Close(t.fd)
+	}
+}
+
+// tableMemSize estimates the memory of a table. For tables reading names
+// from their file, only the indexes are accounted for.
+func tableMemSize(t any) int64 {
+	switch t := t.(type) {
+	case nil:
+		return 0
+	case *elf.SymbolTable:
+		return int64(len(t.Index.Names))*4 + pcIndexSize(t.Index.Values) + pcIndexSize(t.Index.Sizes)
+	case *elf.GoTable:
+		return int64(len(t.Index.Name))*4 + pcIndexSize(t.Index.Entry) + tableMemSize(t.Fallback())
+	case *elf.MaterializedTable:
+		size := pcIndexSize(t.Values) + pcIndexSize(t.Sizes)
+		for _, name := range t.Names {
+			// string header and bytes
+			size += 16 + int64(len(name))
+		}
+		return size
+	case SymbolTable:
+		// perf maps, jitdumps and vdso tables are not shared
+		return int64(t.Size()) * 64
+	}
+	return 0
+}
+
+func pcIndexSize(idx gosym.PCIndex) int64 {
+	if idx.Is32() {
+		return int64(idx.Length()) * 4
+	}
+	return int64(idx.Length()) * 8
+}
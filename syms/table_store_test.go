@@ -0,0 +1,171 @@
+package syms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vietanhduong/profiling/proc"
+	"github.com/vietanhduong/profiling/syms/elf"
+	"golang.org/x/sys/unix"
+)
+
+// newTestModule opens path relative to / as ProcSymbol does, through a file
+// descriptor owned by the module.
+func newTestModule(t *testing.T, path string, procmap *proc.Map, opts *SymbolOptions) *ProcModule {
+	root, err := unix.Open("/", unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	require.NoError(t, err)
+	defer unix.Close(root)
+	abs, err := filepath.Abs(path)
+	require.NoError(t, err)
+	return NewProcModule(path, procmap, newProcPath(abs, unix.Getpid(), root, false), opts)
+}
+
+func TestTableStore_Share(t *testing.T) {
+	store := NewTableStore(1 << 30)
+	opts := &SymbolOptions{DemangleType: DemangleFull, Tables: store}
+	procmap := &proc.Map{StartAddr: 0x401000}
+
+	m1 := newTestModule(t, "elf/testdata/elfs/go20", procmap, opts)
+	require.Equal(t, "main.main", m1.Resolve(0x4817a0))
+	m2 := newTestModule(t, "elf/testdata/elfs/go20", procmap, opts)
+	require.Equal(t, "main.main", m2.Resolve(0x4817a0))
+	require.Same(t, m1.shared, m2.shared)
+	stats := store.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Tables)
+	assert.Greater(t, stats.Size, int64(0))
+
+	// the tables outlive the module which loaded them
+	file, line := m1.ResolveLine(0x4817a0)
+	m1.Cleanup()
+	require.Equal(t, "main.main", m2.Resolve(0x4817a0))
+	file2, line2 := m2.ResolveLine(0x4817a0)
+	assert.NotEmpty(t, file)
+	assert.Equal(t, file, file2)
+	assert.Equal(t, line, line2)
+
+	// unreferenced tables are kept within the budget
+	m2.Cleanup()
+	assert.Equal(t, 1, store.Stats().Tables)
+	m3 := newTestModule(t, "elf/testdata/elfs/go20", procmap, opts)
+	defer m3.Cleanup()
+	require.Equal(t, "main.main", m3.Resolve(0x4817a0))
+	assert.Equal(t, uint64(2), store.Stats().Hits)
+
+	// the options are part of the key
+	m4 := newTestModule(t, "elf/testdata/elfs/go20", procmap, &SymbolOptions{DemangleType: DemangleNone, Tables: store})
+	defer m4.Cleanup()
+	require.Equal(t, "main.main", m4.Resolve(0x4817a0))
+	assert.NotSame(t, m3.shared, m4.shared)
+	assert.Equal(t, 2, store.Stats().Tables)
+}
+
+func TestTableStore_GoFramesOutliveFile(t *testing.T) {
+	store := NewTableStore(1 << 30)
+	opts := &SymbolOptions{DemangleType: DemangleFull, Tables: store}
+	procmap := &proc.Map{StartAddr: 0x401000}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	copyTestFile(t, "elf/testdata/elfs/go20", first)
+	copyTestFile(t, "elf/testdata/elfs/go20", second)
+
+	// the module is read by path, without a file descriptor of its own
+	m1 := NewProcModule(first, procmap, newProcPath(first, 0, -1, true), opts)
+	require.Equal(t, "main.main", m1.Resolve(0x4817a0))
+	require.NoError(t, os.Remove(first))
+	m1.Cleanup()
+	own := NewProcModule(second, procmap, newProcPath(second, 0, -1, true), nil)
+	defer own.Cleanup()
+	file, line := own.ResolveLine(0x4817a0)
+	require.NotEmpty(t, file)
+
+	m2 := NewProcModule(second, procmap, newProcPath(second, 0, -1, true), opts)
+	defer m2.Cleanup()
+	require.Equal(t, "main.main", m2.Resolve(0x4817a0))
+	require.Same(t, store.tables[m2.shared.key], m2.shared)
+	file2, line2 := m2.ResolveLine(0x4817a0)
+	assert.Equal(t, file, file2)
+	assert.Equal(t, line, line2)
+}
+
+func TestTableStore_NoDebugFile(t *testing.T) {
+	store := NewTableStore(1 << 30)
+	dir := t.TempDir()
+	opts := &SymbolOptions{DemangleType: DemangleFull, Tables: store, UseDebugFile: true, HostDebugDirs: []string{dir}}
+	procmap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+	addr := uint64(0x555555554000 + 0x1149)
+
+	// the tables of a module whose debug file is missing are not shared
+	m1 := newTestModule(t, "elf/testdata/elfs/elf.stripped", procmap, opts)
+	defer m1.Cleanup()
+	require.Empty(t, m1.Resolve(addr))
+	assert.Equal(t, 0, store.Stats().Tables)
+
+	copyTestFile(t, "elf/testdata/elfs/elf.debug", filepath.Join(dir, ".build-id", testBuildId[:2], testBuildId[2:]+".debug"))
+	m2 := newTestModule(t, "elf/testdata/elfs/elf.stripped", procmap, opts)
+	defer m2.Cleanup()
+	require.Equal(t, "iter", m2.Resolve(addr))
+	assert.Equal(t, 1, store.Stats().Tables)
+}
+
+func TestTableStore_Evict(t *testing.T) {
+	store := NewTableStore(1)
+	opts := &SymbolOptions{DemangleType: DemangleFull, Tables: store}
+	procmap := &proc.Map{StartAddr: 0x555555555000, FileOffset: 0x1000}
+
+	m1 := newTestModule(t, "elf/testdata/elfs/elf", procmap, opts)
+	require.Equal(t, "iter", m1.Resolve(0x555555554000+0x1149))
+	m2 := newTestModule(t, "elf/testdata/elfs/elf", procmap, opts)
+	require.Equal(t, "iter", m2.Resolve(0x555555554000+0x1149))
+	// referenced tables are not evicted
+	assert.Equal(t, 1, store.Stats().Tables)
+
+	m1.Cleanup()
+	m2.Cleanup()
+	stats := store.Stats()
+	assert.Equal(t, 0, stats.Tables)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, int64(0), stats.Size)
+}
+
+func TestTableStore_Dead(t *testing.T) {
+	store := NewTableStore(1 << 30)
+	table := &elf.MaterializedTable{}
+	shared := store.add("key", table, nil, nil, -1)
+	other := store.acquire("key")
+	require.Same(t, shared, other)
+
+	store.release(shared, true)
+	assert.Nil(t, store.acquire("key"))
+	assert.Equal(t, 0, store.Stats().Tables)
+	// still usable by the other reference
+	store.release(other, false)
+	assert.Equal(t, 0, store.Stats().Tables)
+}
+
+func TestTableStoreKey(t *testing.T) {
+	opts := &SymbolOptions{DemangleType: DemangleFull}
+	var st unix.Stat_t
+	require.NoError(t, unix.Stat("elf/testdata/elfs/elf.nobuildid", &st))
+	inodeKey := fmt.Sprintf("inode:8:1:1234:%d:%d:%d/FULL:false:false:false", st.Size, st.Mtim.Nano(), st.Ctim.Nano())
+	testcases := []struct {
+		path    string
+		procmap *proc.Map
+		key     string
+	}{
+		{"elf/testdata/elfs/elf", nil, "gnu:1fcfa068c5fdb9f31e6d9f3f89019beacb70182d/FULL:false:false:false"},
+		{"elf/testdata/elfs/elf.nobuildid", &proc.Map{DevMajor: 8, DevMinor: 1, Inode: 1234}, inodeKey},
+		{"elf/testdata/elfs/elf.nobuildid", &proc.Map{}, ""},
+	}
+	for _, tt := range testcases {
+		mf, err := elf.NewMMapedElfFile(tt.path)
+		require.NoError(t, err)
+		assert.Equal(t, tt.key, tableStoreKey(mf, tt.procmap, opts))
+		mf.Close()
+	}
+}
@@ -0,0 +1,112 @@
+//go:build ignore
+
+// Generates the synthetic jitdump files used by jitdump_test.go:
+//
+//	go run testdata/gen_jitdump.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+type writer struct {
+	order binary.ByteOrder
+	buf   bytes.Buffer
+}
+
+func (w *writer) u32(v uint32) { binary.Write(&w.buf, w.order, v) }
+func (w *writer) u64(v uint64) { binary.Write(&w.buf, w.order, v) }
+
+func (w *writer) record(id uint32, body []byte) {
+	w.u32(id)
+	w.u32(uint32(16 + len(body)))
+	w.u64(0) // timestamp
+	w.buf.Write(body)
+}
+
+func (w *writer) body(fn func(b *writer)) []byte {
+	b := &writer{order: w.order}
+	fn(b)
+	return b.buf.Bytes()
+}
+
+func (w *writer) codeLoad(addr, size, index uint64, name string) {
+	w.record(0, w.body(func(b *writer) {
+		b.u32(1234)
+		b.u32(1234)
+		b.u64(addr)
+		b.u64(addr)
+		b.u64(size)
+		b.u64(index)
+		b.buf.WriteString(name + "\x00")
+		b.buf.Write(bytes.Repeat([]byte{0xcc}, int(size)))
+	}))
+}
+
+func (w *writer) codeMove(from, to, size, index uint64) {
+	w.record(1, w.body(func(b *writer) {
+		b.u32(1234)
+		b.u32(1234)
+		b.u64(to)
+		b.u64(from)
+		b.u64(to)
+		b.u64(size)
+		b.u64(index)
+	}))
+}
+
+type line struct {
+	addr uint64
+	line uint32
+	file string
+}
+
+func (w *writer) debugInfo(addr uint64, lines []line) {
+	w.record(2, w.body(func(b *writer) {
+		b.u64(addr)
+		b.u64(uint64(len(lines)))
+		for _, l := range lines {
+			b.u64(l.addr)
+			b.u32(l.line)
+			b.u32(0)
+			b.buf.WriteString(l.file + "\x00")
+		}
+	}))
+}
+
+func generate(order binary.ByteOrder, path string) {
+	w := &writer{order: order}
+	// header
+	w.u32(0x4A695444)
+	w.u32(1)
+	w.u32(40)
+	w.u32(62) // EM_X86_64
+	w.u32(0)
+	w.u32(1234)
+	w.u64(0)
+	w.u64(0)
+
+	w.debugInfo(0x7f0000001000, []line{
+		{0x7f0000001000, 10, "Main.java"},
+		{0x7f0000001010, 12, "\xff"},
+		{0x7f0000001020, 20, "Util.java"},
+	})
+	w.codeLoad(0x7f0000001000, 0x40, 1, "LMain;::run")
+	w.codeLoad(0x7f0000002000, 0x20, 2, "LMain;::helper")
+	// unwinding info is skipped
+	w.record(4, make([]byte, 24))
+	w.codeMove(0x7f0000002000, 0x7f0000003000, 0x20, 2)
+	w.codeLoad(0x7f0000004000, 0x10, 3, "LMain;::late")
+	w.record(3, nil)
+
+	if err := os.WriteFile(path, w.buf.Bytes(), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	generate(binary.LittleEndian, "testdata/jit-1234.dump")
+	generate(binary.BigEndian, "testdata/jit-1235.dump")
+}
@@ -2,6 +2,7 @@ package syms
 
 import (
 	"github.com/ianlancetaylor/demangle"
+	"github.com/vietanhduong/profiling/syms/elf"
 )
 
 type SymbolTable interface {
@@ -11,9 +12,43 @@ type SymbolTable interface {
 	Size() int
 }
 
+// FrameTable is implemented by tables which know the source position and
+// the inlined calls of an address, such as DWARF and Go pclntab tables.
+type FrameTable interface {
+	ResolveLine(addr uint64) (string, int)
+	ResolveFrames(addr uint64) []elf.Frame
+}
+
 type SymbolOptions struct {
 	DemangleType DemangleType
 	UseDebugFile bool
+	// UseDwarf loads DWARF line tables (from the module itself or its debug
+	// file) to resolve the source file and line of each address.
+	UseDwarf bool
+	// KeepRustHash keeps the hash which ends demangled Rust legacy names,
+	// e.g. rust::iter::hd29e73094b59ca81.
+	KeepRustHash bool
+	// Cache stores the symbol tables of modules with a build ID, nil to
+	// parse them every time. Only the symbol names are cached, the frames
+	// of Go modules are still built from their pclntab on load.
+	Cache *SymbolCache
+	// Tables shares the tables of ELF modules between resolvers using the
+	// same store, nil to load them per process.
+	Tables *TableStore
+	// Debuginfod downloads the debug files which are not found in the
+	// filesystem of the process when UseDebugFile or UseDwarf is set. The
+	// downloads run in the background, the modules are reloaded once done.
+	Debuginfod *DebuginfodClient
+	// DebugDirs are the directories searched for debug files in the
+	// filesystem of the process, /usr/lib/debug if empty.
+	DebugDirs []string
+	// HostDebugDirs are the directories searched for debug files in the
+	// filesystem of the host, after DebugDirs.
+	HostDebugDirs []string
+	// Deferred resolves addresses to their module only, no symbol table is
+	// loaded: symbols have no name but the build ID of their module and
+	// their file offset, to be resolved later by an OfflineResolver.
+	Deferred bool
 }
 
 type DemangleType string
@@ -28,6 +63,7 @@ const (
 var defaultSymbolOpts = &SymbolOptions{
 	DemangleType: DemangleFull,
 	UseDebugFile: false,
+	UseDwarf:     false,
 }
 
 func (dt DemangleType) ToOptions() []demangle.Option {
@@ -50,10 +86,47 @@ const (
 	EXEC    ProcModuleType = "EXEC"
 	SO      ProcModuleType = "SO"
 	VDSO    ProcModuleType = "VDSO"
+	PERFMAP ProcModuleType = "PERFMAP"
+	JITDUMP ProcModuleType = "JITDUMP"
 )
 
 type Symbol struct {
+	// Start is the offset of the address from the load base of its module
+	// (ProcSymbol), the address of JIT code or the address of the kernel
+	// symbol (KernSym).
 	Start  uint64 `json:"start,omitempty"`
 	Name   string `json:"name,omitempty"`
 	Module string `json:"module,omitempty"`
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+
+	// Addr is the resolved address.
+	Addr uint64 `json:"addr,omitempty"`
+	// FuncStart is the address where the function at Addr starts and
+	// FuncOffset the offset of Addr in it, both are 0 if there is no symbol.
+	FuncStart  uint64 `json:"func_start,omitempty"`
+	FuncOffset uint64 `json:"func_offset,omitempty"`
+	// FileOffset is the offset of Addr in the file of its module.
+	FileOffset uint64 `json:"file_offset,omitempty"`
+	// BuildId is the build ID of the module, empty if it has none.
+	BuildId elf.BuildId `json:"build_id"`
+	// Mapping is the memory mapping containing Addr, zero if Addr is not
+	// mapped.
+	Mapping Mapping `json:"mapping"`
+	// UnknownModule is set when no module contains Addr. A module which
+	// has no symbol for Addr only leaves Name empty.
+	UnknownModule bool `json:"unknown_module,omitempty"`
+}
+
+func (s *Symbol) setFuncStart(start uint64) {
+	if start != 0 {
+		s.FuncStart, s.FuncOffset = start, s.Addr-start
+	}
+}
+
+// Mapping is the range [Start, End) of memory mapped from Offset of a file.
+type Mapping struct {
+	Start  uint64 `json:"start,omitempty"`
+	End    uint64 `json:"end,omitempty"`
+	Offset uint64 `json:"offset,omitempty"`
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/vietanhduong/profiling/proc"
@@ -16,9 +17,16 @@ type vdsoStatus struct {
 	err   error
 }
 
-var vstatus *vdsoStatus
+var (
+	// vdsoMu guards vstatus, vDSO modules of every process share the image
+	// of the vDSO of the profiler
+	vdsoMu  sync.Mutex
+	vstatus *vdsoStatus
+)
 
 func buildVDSOResolver() (SymbolTable, error) {
+	vdsoMu.Lock()
+	defer vdsoMu.Unlock()
 	if vstatus == nil {
 		vstatus = &vdsoStatus{}
 		vstatus.image, vstatus.err = findVDSO(unix.Getpid())
@@ -93,6 +101,12 @@ func buildVDSOImage(procmap *proc.Map, pid int) string {
 	return tmpfile.Name()
 }
 
+func cleanupVDSO() {
+	vdsoMu.Lock()
+	defer vdsoMu.Unlock()
+	vstatus.Cleanup()
+}
+
 func (s *vdsoStatus) Cleanup() {
 	if s == nil || s.image == "" {
 		return